@@ -0,0 +1,90 @@
+// Package atomicfile writes files under a temporary name next to their
+// destination and renames them into place once complete and synced, so
+// readers, later runs and crashes never see a file half written.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Suffix ends the name of every temporary file, so what an interrupted run
+// left behind can be recognised and removed.
+const Suffix = ".part"
+
+// File is a file being written in place of dest.
+type File struct {
+	*os.File
+	dest string
+}
+
+// Create starts writing dest. The temporary file gets a unique name in the
+// same directory, so concurrent writers of dest do not clobber each other's
+// data. The caller must call Finish.
+func Create(dest string) (*File, error) {
+	f, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*"+Suffix)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &File{File: f, dest: dest}, nil
+}
+
+// Finish completes the file if *err is nil and discards it otherwise,
+// leaving any previous dest untouched. The file is synced to disk, checked
+// with verify if it is not nil, renamed over dest and the rename synced. A
+// failure is stored in *err. It is meant to be deferred by the function
+// writing the file.
+func (f *File) Finish(err *error, verify func(*os.File) error) {
+	if *err == nil {
+		*err = f.commit(verify)
+	}
+	if *err != nil {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func (f *File) commit(verify func(*os.File) error) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if verify != nil {
+		if err := verify(f.File); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), f.dest); err != nil {
+		return err
+	}
+	SyncDir(filepath.Dir(f.dest))
+	return nil
+}
+
+// WriteFile writes data to dest through a synced temporary file.
+func WriteFile(dest string, data []byte) (err error) {
+	f, err := Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Finish(&err, nil)
+	_, err = f.Write(data)
+	return err
+}
+
+// SyncDir flushes a directory entry change, such as a rename, to disk.
+// Errors are ignored: not every platform can sync directories, and the
+// file itself is already durable.
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
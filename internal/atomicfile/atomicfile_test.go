@@ -0,0 +1,56 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "library.json")
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFile(dest, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(dest); err != nil || string(got) != data {
+			t.Errorf("content = %q, %v; want %q", got, err, data)
+		}
+	}
+	info, err := os.Stat(dest)
+	if err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, %v; want 0644", info.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestFinishDiscardsOnError(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "a.cbz")
+	if err := os.WriteFile(dest, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(verify func(*os.File) error) (err error) {
+		f, err := Create(dest)
+		if err != nil {
+			return err
+		}
+		defer f.Finish(&err, verify)
+		_, err = f.WriteString("new")
+		return err
+	}
+	bad := errors.New("bad archive")
+	if err := write(func(*os.File) error { return bad }); !errors.Is(err, bad) {
+		t.Fatalf("write = %v; want the verify error", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "old" {
+		t.Errorf("content = %q after a failed write; want the old file kept", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
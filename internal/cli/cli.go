@@ -0,0 +1,485 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mangadl/internal/config"
+	"mangadl/internal/domain"
+	"mangadl/internal/downloader"
+	"mangadl/internal/history"
+	"mangadl/internal/scraper"
+	"mangadl/internal/selection"
+)
+
+// Exit codes returned by Run and Main.
+const (
+	ExitOK      = 0
+	ExitFailure = 1 // a fetch failed or at least one chapter failed
+	ExitUsage   = 2
+
+	// ExitInterrupted is returned when SIGINT or SIGTERM stopped a download.
+	// Pages saved so far are kept, so rerunning the command resumes it.
+	ExitInterrupted = 130
+)
+
+var usage = `Usage:
+  mangadl [--config FILE] [flags]          start the interactive TUI
+  mangadl [--config FILE] <command> ...
+
+Commands:
+  list <url> [--json]                      list the chapters of a series
+  download <url> [flags]                   download chapters without the TUI
+  follow <url> [--out DIR] [--backfill]    add a series to the library
+  unfollow <url>                           remove a series from the library
+  library                                  list followed series
+  update [--dry-run] [flags]               download new chapters of followed series
+  history [--failed] [--limit N] [--json]  list past chapter downloads
+  retry [--dry-run] [flags]                download again the chapters whose last attempt failed
+
+Download, update and retry flags:
+  --chapters SPEC   (download only) chapters to download (default: all), a comma-separated
+                    list of numbers and ranges ("1-20,25,30.5", "40-"), "latest:N" for the
+                    newest N, "vol:N" for a volume and "!" to exclude ("vol:3,!12")
+  --dry-run         (download only) print the chapters --chapters selects and exit
+  --out DIR         (download only) output root directory (default: output_dir, ` + config.Default().OutputDir + `)
+  --format FORMAT   archive format, cbz, epub or pdf (default: output_format, ` + config.Default().OutputFormat + `)
+  --workers N       chapters downloaded in parallel (default: max_chapter_workers, ` + fmt.Sprint(config.Default().MaxChapterWorkers) + `)
+  --rps N           requests per second per host, for every source (default: rate_limit.rps, ` + fmt.Sprint(config.Default().RateLimit.RPS) + `)
+  --burst N         requests allowed in a burst per host, for every source (default: rate_limit.burst,
+                    or 2 x rps with --rps); without --rps each source keeps its rate
+  --retries N       retries per request on timeouts, 429 and 5xx (default: retry.attempts - 1, ` + fmt.Sprint(config.Default().Retry.Attempts-1) + `)
+  --convert FORMAT  convert pages to jpeg or png before archiving (default: convert.format, keep)
+  --quality N       JPEG quality for converted pages (default: convert.quality, ` + fmt.Sprint(config.Default().Convert.Quality) + `)
+  --grayscale       convert pages to grayscale
+  --strip-metadata  re-encode pages to drop embedded metadata
+  --device NAME     resize and convert pages for an e-reader, e.g. kindle-paperwhite or kobo-libra
+  --crop            trim white page margins
+  --spreads MODE    "split" landscape double pages in two (right first) or "rotate" them
+  --volumes SPEC    merge chapters into volume archives: a chapter count ("10"),
+                    chapter ranges ("1-10,11-25") or "scraped" to use the site's volumes
+  --json            print newline-delimited JSON events instead of text
+
+The TUI takes --out and the flags above except --chapters, --dry-run and
+--json. Given before a command, they apply to it as well.
+
+Settings are read from the config file (--config, $MANGADL_CONFIG or
+$XDG_CONFIG_HOME/mangadl/config.yaml), then MANGADL_* environment variables
+named after the config keys (e.g. MANGADL_RETRY_ATTEMPTS), then flags.
+
+Ctrl+C stops a download after recording the pages saved so far; run the same
+command again to resume. Press Ctrl+C twice to exit immediately.
+
+Every chapter download is recorded in history.jsonl and logged to the
+rotating mangadl.log, both in $XDG_DATA_HOME/mangadl (~/.local/share/mangadl
+by default).
+`
+
+type command func(ctx context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int
+
+var commands = map[string]command{
+	"list":     runList,
+	"download": runDownload,
+	"follow":   runFollow,
+	"unfollow": runUnfollow,
+	"library":  runLibrary,
+	"update":   runUpdate,
+	"history":  runHistory,
+	"retry":    runRetry,
+}
+
+// Run executes a headless subcommand and returns the process exit code. It
+// is Main without a TUI, so args must name a subcommand.
+func Run(args []string, stdout, stderr io.Writer) int {
+	return Main(args, stdout, stderr, nil)
+}
+
+// Main parses the global flags in args, loads the config and then runs the
+// subcommand that follows the flags or, if there is none, tui with the
+// resulting config. It returns the process exit code. The first SIGINT or
+// SIGTERM cancels a subcommand's context; a second one gets the default
+// behaviour and kills the process.
+func Main(args []string, stdout, stderr io.Writer, tui func(cfg config.Config) int) int {
+	// --config has to be known to load the config, which the other flags
+	// take their defaults from, so the flags are parsed twice: first to find
+	// --config and the command, then into the loaded config.
+	cfg := config.Default()
+	global, configPath, dl := globalFlags(&cfg, stderr)
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	rest := global.Args()
+	if len(rest) == 0 && tui == nil {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+
+	var run command
+	if len(rest) > 0 {
+		if rest[0] == "help" {
+			fmt.Fprint(stdout, usage)
+			return ExitOK
+		}
+		var ok bool
+		if run, ok = commands[rest[0]]; !ok {
+			fmt.Fprintf(stderr, "unknown command %q\n\n%s", rest[0], usage)
+			return ExitUsage
+		}
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitUsage
+	}
+	global, _, dl = globalFlags(&cfg, stderr)
+	if err := global.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if err := dl.apply(&cfg); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitUsage
+	}
+	if run == nil {
+		return tui(cfg)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return run(ctx, cfg, rest[1:], stdout, stderr)
+}
+
+// globalFlags returns the flag set for the flags that may precede a
+// command: --config, whose value is returned, --out and the download flags,
+// bound to cfg.
+func globalFlags(cfg *config.Config, stderr io.Writer) (*flag.FlagSet, *string, *downloadFlags) {
+	fs := flag.NewFlagSet("mangadl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	configPath := fs.String("config", "", "config file")
+	fs.StringVar(&cfg.OutputDir, "out", cfg.OutputDir, "output root directory")
+	return fs, configPath, bindDownloadFlags(fs, cfg)
+}
+
+func runList(ctx context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "print JSON")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+
+	details, err := scraper.New(cfg).FetchMangaDetails(ctx, positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(details); err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return ExitFailure
+		}
+		return ExitOK
+	}
+
+	fmt.Fprintf(stdout, "%s (%d chapters)\n", details.Title, len(details.Chapters))
+	for _, c := range details.Chapters {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\n", c.Number, c.Name, c.URL)
+	}
+	return ExitOK
+}
+
+func runDownload(ctx context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	chapterSpec := fs.String("chapters", "", "chapters to download")
+	dryRun := fs.Bool("dry-run", false, "print the selected chapters without downloading")
+	fs.StringVar(&cfg.OutputDir, "out", cfg.OutputDir, "output root directory")
+	dl := bindDownloadFlags(fs, &cfg)
+	asJSON := fs.Bool("json", false, "print JSON events")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	if err := dl.apply(&cfg); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitUsage
+	}
+
+	sel, err := selection.Parse(*chapterSpec)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitUsage
+	}
+
+	log, closeLog := OpenLog(cfg, stderr)
+	defer closeLog()
+	out := newReporter(stdout, *asJSON, log)
+	s := scraper.New(cfg)
+	d := downloader.New(cfg, s)
+
+	details, err := s.FetchMangaDetails(ctx, positional[0])
+	if err != nil {
+		out.report(event{Event: "error", Error: err.Error()})
+		return ExitFailure
+	}
+
+	chapters := sel.Select(details.Chapters)
+	if len(chapters) == 0 {
+		out.report(event{Event: "error", Series: details.Title, Error: "no chapters matched"})
+		return ExitFailure
+	}
+	if *dryRun {
+		out.report(event{Event: "selection", Series: details.Title, Total: len(chapters), Selection: selection.Describe(chapters)})
+		for _, c := range chapters {
+			out.report(event{Event: "selected", Chapter: c.Name, URL: c.URL})
+		}
+		return ExitOK
+	}
+
+	mangaDir, err := downloader.SeriesDir(cfg, details)
+	if err != nil {
+		out.report(event{Event: "error", Series: details.Title, Error: err.Error()})
+		return ExitFailure
+	}
+	out.report(event{Event: "series", Series: details.Title, Total: len(chapters), Dir: mangaDir})
+
+	hist := OpenHistory(stderr)
+	defer hist.Close()
+	succeeded := downloadAll(ctx, d, cfg, details, chapters, mangaDir, hist, out)
+	failed := len(chapters) - len(succeeded)
+
+	out.report(event{Event: "summary", Series: details.Title, Total: len(chapters), Failed: failed})
+	if ctx.Err() != nil {
+		out.report(event{Event: "interrupted", Series: details.Title})
+		return ExitInterrupted
+	}
+	if failed > 0 {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// downloadAll downloads chapters with at most cfg.MaxChapterWorkers in flight
+// and returns the chapters that succeeded. Every chapter attempted is
+// recorded in hist, which may be nil. When cfg.Volumes is set, chapters
+// are archived as part of their volume and only count as succeeded once the
+// volume archive is written. Once ctx is cancelled no further chapters are
+// started and no volumes are written.
+func downloadAll(ctx context.Context, d *downloader.Downloader, cfg config.Config, manga *domain.MangaDetails, chapters []domain.Chapter, mangaDir string, hist *history.Store, out *reporter) []domain.Chapter {
+	// Validate has already accepted the spec.
+	volumes, _ := config.ParseVolumes(cfg.Volumes)
+	download := d.DownloadChapter
+	if volumes.Enabled() {
+		download = d.FetchChapter
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]*domain.ChapterResult)
+		done    int
+	)
+	sem := make(chan struct{}, cfg.MaxChapterWorkers)
+	total := len(chapters)
+	title := manga.Title
+
+	for _, chapter := range chapters {
+		wg.Add(1)
+		go func(ch domain.Chapter) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			out.report(event{Event: "started", Series: title, Chapter: ch.Name, URL: ch.URL, Total: total})
+			started := time.Now()
+			result, err := download(ctx, manga, ch, mangaDir)
+			if herr := hist.Record(history.NewEntry(manga, mangaDir, ch, started, result, err)); herr != nil {
+				out.report(event{Event: "error", Series: title, Chapter: ch.Name, Error: fmt.Sprintf("history: %v", herr)})
+			}
+
+			mu.Lock()
+			done++
+			ev := event{Event: "finished", Series: title, Chapter: ch.Name, URL: ch.URL, Done: done, Total: total}
+			if result != nil {
+				ev.Pages = result.Total
+				ev.PagesDone = result.PagesDone()
+				ev.FailedPages = result.Failed
+				ev.Archive = result.Archive
+				ev.Skipped = result.Skipped
+				ev.Retries = result.Retries
+			}
+			if err != nil {
+				ev.Event = "failed"
+				ev.Error = err.Error()
+			} else {
+				results[ch.Key()] = result
+			}
+			mu.Unlock()
+			out.report(ev)
+		}(chapter)
+	}
+	wg.Wait()
+
+	var succeeded []domain.Chapter
+	for _, ch := range chapters {
+		if results[ch.Key()] != nil {
+			succeeded = append(succeeded, ch)
+		}
+	}
+	if !volumes.Enabled() {
+		return succeeded
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return writeVolumes(ctx, d, volumes, manga, chapters, results, mangaDir, out)
+}
+
+// writeVolumes archives the volumes holding chapters fetched this run and
+// returns the selected chapters whose volume is archived. A volume with a
+// failed chapter is not written, and neither is one whose selected chapters
+// were all archived before; see downloader.SelectVolumes.
+func writeVolumes(ctx context.Context, d *downloader.Downloader, spec config.VolumeSpec, manga *domain.MangaDetails, selected []domain.Chapter, results map[string]*domain.ChapterResult, mangaDir string, out *reporter) []domain.Chapter {
+	var succeeded []domain.Chapter
+	for _, vol := range downloader.SelectVolumes(spec, manga, selected, results) {
+		if !vol.Stale {
+			succeeded = append(succeeded, vol.Selected...)
+			continue
+		}
+
+		ev := event{Event: "volume", Series: manga.Title, Volume: vol.Title, Total: len(vol.Chapters)}
+		archive, err := d.WriteVolume(ctx, manga, vol.Volume, mangaDir)
+		if err != nil {
+			ev.Error = err.Error()
+		} else {
+			ev.Archive = archive
+			succeeded = append(succeeded, vol.Selected...)
+		}
+		out.report(ev)
+	}
+	return succeeded
+}
+
+// downloadFlags holds the flags shared by download and update that need
+// validation before they are applied to the configuration.
+type downloadFlags struct {
+	retries int
+	rps     float64
+	burst   int
+}
+
+// bindDownloadFlags registers the worker, retry, rate limit, conversion and
+// volume flags on fs with defaults taken from cfg.
+func bindDownloadFlags(fs *flag.FlagSet, cfg *config.Config) *downloadFlags {
+	f := &downloadFlags{}
+	fs.IntVar(&cfg.MaxChapterWorkers, "workers", cfg.MaxChapterWorkers, "chapters downloaded in parallel")
+	fs.StringVar(&cfg.OutputFormat, "format", cfg.OutputFormat, "archive format")
+	fs.StringVar(&cfg.Convert.Format, "convert", cfg.Convert.Format, "convert pages to this format")
+	fs.IntVar(&cfg.Convert.Quality, "quality", cfg.Convert.Quality, "JPEG quality")
+	fs.BoolVar(&cfg.Convert.Grayscale, "grayscale", cfg.Convert.Grayscale, "convert pages to grayscale")
+	fs.BoolVar(&cfg.Convert.StripMetadata, "strip-metadata", cfg.Convert.StripMetadata, "re-encode pages to drop metadata")
+	fs.StringVar(&cfg.Convert.Device, "device", cfg.Convert.Device, "e-reader profile")
+	fs.BoolVar(&cfg.Convert.CropMargins, "crop", cfg.Convert.CropMargins, "trim white page margins")
+	fs.StringVar(&cfg.Convert.Spreads, "spreads", cfg.Convert.Spreads, "split or rotate landscape pages")
+	fs.StringVar(&cfg.Volumes, "volumes", cfg.Volumes, "merge chapters into volume archives")
+	fs.IntVar(&f.retries, "retries", cfg.Retry.Attempts-1, "retries per request")
+	fs.Float64Var(&f.rps, "rps", 0, "requests per second per host")
+	fs.IntVar(&f.burst, "burst", 0, "request burst per host")
+	return f
+}
+
+// apply copies the parsed flags into cfg and validates the result. A
+// non-zero --rps overrides the default and every per-source rate limit;
+// --burst on its own keeps every rate and replaces only the burst.
+func (f *downloadFlags) apply(cfg *config.Config) error {
+	if f.retries < 0 {
+		return errors.New("--retries must not be negative")
+	}
+	if f.rps < 0 || f.burst < 0 {
+		return errors.New("--rps and --burst must not be negative")
+	}
+	cfg.Retry.Attempts = f.retries + 1
+	cfg.Convert.Format = config.NormalizeFormat(cfg.Convert.Format)
+	cfg.OutputFormat = strings.ToLower(cfg.OutputFormat)
+
+	if f.rps > 0 {
+		burst := f.burst
+		if burst == 0 {
+			burst = max(1, int(f.rps*2))
+		}
+		lim := config.RateLimitConfig{RPS: f.rps, Burst: burst}
+		cfg.RateLimit = lim
+		cfg.Sources = make(map[string]config.RateLimitConfig)
+		for _, name := range scraper.SourceNames() {
+			cfg.Sources[name] = lim
+		}
+	} else if f.burst > 0 {
+		sources := make(map[string]config.RateLimitConfig)
+		for name, lim := range cfg.Sources {
+			sources[name] = config.RateLimitConfig{RPS: lim.RPS, Burst: f.burst}
+		}
+		for _, src := range scraper.Sources() {
+			lim := scraper.SourceLimit(*cfg, src)
+			sources[src.Name()] = config.RateLimitConfig{RPS: lim.RPS, Burst: f.burst}
+		}
+		cfg.RateLimit.Burst = f.burst
+		cfg.Sources = sources
+	}
+	return cfg.Validate()
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments, which the standard flag package does not allow on its own.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mangadl/internal/config"
+	"mangadl/internal/domain"
+	"mangadl/internal/history"
+	"mangadl/internal/scraper"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	out := fs.String("out", "", "")
+	asJSON := fs.Bool("json", false, "")
+
+	positional, err := parseInterspersed(fs, []string{"https://example.com/a", "--out", "dir", "--json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(positional, []string{"https://example.com/a"}) {
+		t.Errorf("positional = %v", positional)
+	}
+	if *out != "dir" || !*asJSON {
+		t.Errorf("flags not parsed: out=%q json=%v", *out, *asJSON)
+	}
+}
+
+func TestBurstWithoutRPS(t *testing.T) {
+	cfg := config.Default()
+	cfg.Sources = map[string]config.RateLimitConfig{"Elsewhere": {RPS: 1, Burst: 2}}
+	before := make(map[string]float64)
+	for _, src := range scraper.Sources() {
+		before[src.Name()] = scraper.SourceLimit(cfg, src).RPS
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	dl := bindDownloadFlags(fs, &cfg)
+	if err := fs.Parse([]string{"--burst", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.apply(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (config.RateLimitConfig{RPS: config.Default().RateLimit.RPS, Burst: 3}); cfg.RateLimit != want {
+		t.Errorf("rate_limit = %+v; want %+v", cfg.RateLimit, want)
+	}
+	if got := cfg.Sources["Elsewhere"]; got != (config.RateLimitConfig{RPS: 1, Burst: 3}) {
+		t.Errorf("configured source limit = %+v; want its rate with burst 3", got)
+	}
+	for _, src := range scraper.Sources() {
+		if got := scraper.SourceLimit(cfg, src); got.RPS != before[src.Name()] || got.Burst != 3 {
+			t.Errorf("%s limit = %+v; want rps %v and burst 3", src.Name(), got, before[src.Name()])
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	if code := Run(nil, io.Discard, io.Discard); code != ExitUsage {
+		t.Errorf("Run(nil) = %d; want %d", code, ExitUsage)
+	}
+	if code := Run([]string{"bogus"}, io.Discard, io.Discard); code != ExitUsage {
+		t.Errorf("Run(bogus) = %d; want %d", code, ExitUsage)
+	}
+	if code := Run([]string{"download"}, io.Discard, io.Discard); code != ExitUsage {
+		t.Errorf("Run(download) = %d; want %d", code, ExitUsage)
+	}
+}
+
+func TestMainTUI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("output_dir: from-file\nmax_chapter_workers: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got *config.Config
+	tui := func(cfg config.Config) int {
+		got = &cfg
+		return ExitOK
+	}
+
+	if code := Main([]string{"--config", path, "--workers", "5", "--rps", "3"}, io.Discard, io.Discard, tui); code != ExitOK || got == nil {
+		t.Fatalf("Main = %d; want the TUI started", code)
+	}
+	if got.OutputDir != "from-file" || got.MaxChapterWorkers != 5 || got.RateLimit.RPS != 3 {
+		t.Errorf("TUI config = %+v; want the file and flags applied", got)
+	}
+
+	got = nil
+	if code := Main([]string{"--workers", "-1"}, io.Discard, io.Discard, tui); code != ExitUsage || got != nil {
+		t.Errorf("Main with an invalid flag = %d; want %d without the TUI", code, ExitUsage)
+	}
+	if code := Main([]string{"--config", path, "bogus"}, io.Discard, io.Discard, tui); code != ExitUsage || got != nil {
+		t.Errorf("Main(bogus) = %d; want %d without the TUI", code, ExitUsage)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	path, err := history.DefaultPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err := history.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := &domain.MangaDetails{Title: "A", URL: "https://example.com/a"}
+	for _, c := range []struct {
+		name string
+		err  error
+	}{{"Chapter 1", errors.New("boom")}, {"Chapter 2", errors.New("boom")}, {"Chapter 1", nil}} {
+		chapter := domain.Chapter{Name: c.name, ID: c.name}
+		hist.Record(history.NewEntry(series, "a", chapter, time.Now(), nil, c.err))
+	}
+	hist.Close()
+
+	var out bytes.Buffer
+	if code := Run([]string{"history"}, &out, io.Discard); code != ExitOK {
+		t.Fatalf("history = %d", code)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 3 {
+		t.Errorf("history printed %d lines; want 3:\n%s", n, out.String())
+	}
+
+	out.Reset()
+	if code := Run([]string{"history", "--failed"}, &out, io.Discard); code != ExitOK {
+		t.Fatalf("history --failed = %d", code)
+	}
+	if got := out.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, "failed\tA\tChapter 2\tboom") {
+		t.Errorf("history --failed printed:\n%s", got)
+	}
+}
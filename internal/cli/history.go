@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"mangadl/internal/config"
+	"mangadl/internal/downloader"
+	"mangadl/internal/history"
+	"mangadl/internal/logfile"
+	"mangadl/internal/scraper"
+)
+
+// OpenLog opens the log file configured by cfg.Log and returns a logger
+// writing to it and a function closing it. The log is not essential, so
+// when it cannot be opened a warning is printed and nothing is logged.
+func OpenLog(cfg config.Config, stderr io.Writer) (*slog.Logger, func()) {
+	discard := slog.New(slog.DiscardHandler)
+	if cfg.Log.MaxSize == 0 {
+		return discard, func() {}
+	}
+	path, err := logfile.DefaultPath()
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: log file: %v\n", err)
+		return discard, func() {}
+	}
+	w, err := logfile.Open(path, cfg.Log.MaxSize, cfg.Log.Keep)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: log file: %v\n", err)
+		return discard, func() {}
+	}
+	return logfile.NewLogger(w), func() { w.Close() }
+}
+
+// OpenHistory opens the download history for appending. Like the log, it
+// is not essential: when it cannot be opened a warning is printed and the
+// nil store returned records nothing.
+func OpenHistory(stderr io.Writer) *history.Store {
+	path, err := history.DefaultPath()
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: history: %v\n", err)
+		return nil
+	}
+	hist, err := history.Open(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: history: %v\n", err)
+		return nil
+	}
+	return hist
+}
+
+func loadHistory(stderr io.Writer) ([]history.Entry, bool) {
+	path, err := history.DefaultPath()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return nil, false
+	}
+	entries, err := history.Load(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return nil, false
+	}
+	return entries, true
+}
+
+func runHistory(_ context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	failedOnly := fs.Bool("failed", false, "only chapters whose last attempt failed")
+	limit := fs.Int("limit", 50, "newest entries to print, 0 for all")
+	asJSON := fs.Bool("json", false, "print JSON")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 0 || *limit < 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+
+	entries, ok := loadHistory(stderr)
+	if !ok {
+		return ExitFailure
+	}
+	if *failedOnly {
+		entries = history.Failed(entries)
+	}
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[len(entries)-*limit:]
+	}
+
+	enc := json.NewEncoder(stdout)
+	for _, e := range entries {
+		if *asJSON {
+			if err := enc.Encode(e); err != nil {
+				fmt.Fprintf(stderr, "error: %v\n", err)
+				return ExitFailure
+			}
+			continue
+		}
+		detail := e.Error
+		switch {
+		case e.Skipped:
+			detail = "already downloaded"
+		case e.Status != history.StatusFailed:
+			detail = fmt.Sprintf("%d/%d pages, %.1f MB, %s", e.PagesDone, e.Pages, float64(e.Bytes)/(1<<20), e.Duration().Round(100*time.Millisecond))
+		}
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\t%s\n", e.Finished.Format("2006-01-02 15:04"), e.Status, e.Series, e.Chapter, detail)
+	}
+	return ExitOK
+}
+
+// runRetry downloads again the chapters whose last attempt failed, into
+// the series directory they were downloaded to before, so the pages saved
+// then are reused.
+func runRetry(ctx context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("retry", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dryRun := fs.Bool("dry-run", false, "only list the failed chapters")
+	dl := bindDownloadFlags(fs, &cfg)
+	asJSON := fs.Bool("json", false, "print JSON events")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	if err := dl.apply(&cfg); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitUsage
+	}
+
+	entries, ok := loadHistory(stderr)
+	if !ok {
+		return ExitFailure
+	}
+
+	log, closeLog := OpenLog(cfg, stderr)
+	defer closeLog()
+	var hist *history.Store
+	if !*dryRun {
+		hist = OpenHistory(stderr)
+		defer hist.Close()
+	}
+	out := newReporter(stdout, *asJSON, log)
+	s := scraper.New(cfg)
+	d := downloader.New(cfg, s)
+	code := ExitOK
+
+	for _, group := range history.BySeries(history.Failed(entries)) {
+		if ctx.Err() != nil {
+			out.report(event{Event: "interrupted"})
+			return ExitInterrupted
+		}
+		latest := group[len(group)-1]
+		details, err := s.FetchMangaDetails(ctx, latest.SeriesURL)
+		if err != nil {
+			out.report(event{Event: "error", Series: latest.Series, URL: latest.SeriesURL, Error: err.Error()})
+			code = ExitFailure
+			continue
+		}
+
+		chapters, missing := history.Match(details, group)
+		for _, e := range missing {
+			out.report(event{Event: "error", Series: details.Title, Chapter: e.Chapter, Error: fmt.Sprintf("%s is no longer listed", e.Chapter)})
+			code = ExitFailure
+		}
+		out.report(event{Event: "series", Series: details.Title, Total: len(chapters), Dir: latest.Dir})
+
+		if *dryRun {
+			for _, c := range chapters {
+				out.report(event{Event: "selected", Series: details.Title, Chapter: c.Name, URL: c.URL})
+			}
+			continue
+		}
+		if len(chapters) > 0 {
+			succeeded := downloadAll(ctx, d, cfg, details, chapters, latest.Dir, hist, out)
+			if len(succeeded) < len(chapters) {
+				code = ExitFailure
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		out.report(event{Event: "interrupted"})
+		return ExitInterrupted
+	}
+	return code
+}
@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"mangadl/internal/config"
+	"mangadl/internal/downloader"
+	"mangadl/internal/library"
+	"mangadl/internal/scraper"
+)
+
+func openLibrary(stderr io.Writer) (*library.Library, bool) {
+	path, err := library.DefaultPath()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return nil, false
+	}
+	lib, err := library.Load(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return nil, false
+	}
+	return lib, true
+}
+
+func runFollow(ctx context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("follow", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.OutputDir, "out", cfg.OutputDir, "output root directory")
+	backfill := fs.Bool("backfill", false, "download existing chapters on the next update")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+
+	lib, ok := openLibrary(stderr)
+	if !ok {
+		return ExitFailure
+	}
+
+	details, err := scraper.New(cfg).FetchMangaDetails(ctx, positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	outputDir, err := downloader.SeriesDir(cfg, details)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	series, added := lib.Follow(library.Series{
+		URL:       positional[0],
+		Title:     details.Title,
+		OutputDir: outputDir,
+	})
+	if added && !*backfill {
+		series.MarkKnown(details.Chapters...)
+	}
+	series.LastChecked = time.Now()
+
+	if err := lib.Save(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	verb := "following"
+	if !added {
+		verb = "updated"
+	}
+	fmt.Fprintf(stdout, "%s\t%s\t%d chapters\t%s\n", verb, series.Title, len(details.Chapters), series.OutputDir)
+	return ExitOK
+}
+
+func runUnfollow(_ context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	lib, ok := openLibrary(stderr)
+	if !ok {
+		return ExitFailure
+	}
+	if !lib.Unfollow(args[0]) {
+		fmt.Fprintf(stderr, "error: %s is not followed\n", args[0])
+		return ExitFailure
+	}
+	if err := lib.Save(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	fmt.Fprintf(stdout, "unfollowed\t%s\n", args[0])
+	return ExitOK
+}
+
+func runLibrary(_ context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	if len(args) != 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	lib, ok := openLibrary(stderr)
+	if !ok {
+		return ExitFailure
+	}
+	for _, s := range lib.Series {
+		checked := "never"
+		if !s.LastChecked.IsZero() {
+			checked = s.LastChecked.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(stdout, "%s\t%d known\tchecked %s\t%s\n", s.Title, len(s.Known), checked, s.URL)
+	}
+	return ExitOK
+}
+
+func runUpdate(ctx context.Context, cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dryRun := fs.Bool("dry-run", false, "only report new chapters")
+	dl := bindDownloadFlags(fs, &cfg)
+	asJSON := fs.Bool("json", false, "print JSON events")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	if err := dl.apply(&cfg); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitUsage
+	}
+
+	lib, ok := openLibrary(stderr)
+	if !ok {
+		return ExitFailure
+	}
+
+	log, closeLog := OpenLog(cfg, stderr)
+	defer closeLog()
+	hist := OpenHistory(stderr)
+	defer hist.Close()
+	out := newReporter(stdout, *asJSON, log)
+	s := scraper.New(cfg)
+	d := downloader.New(cfg, s)
+	code := ExitOK
+
+	for _, series := range lib.Series {
+		if ctx.Err() != nil {
+			out.report(event{Event: "interrupted"})
+			return ExitInterrupted
+		}
+		details, err := s.FetchMangaDetails(ctx, series.URL)
+		if err != nil {
+			out.report(event{Event: "error", Series: series.Title, URL: series.URL, Error: err.Error()})
+			code = ExitFailure
+			continue
+		}
+		series.Title = details.Title
+
+		manifest, err := downloader.LoadManifest(series.OutputDir)
+		if err != nil {
+			out.report(event{Event: "error", Series: series.Title, Error: err.Error()})
+			code = ExitFailure
+			continue
+		}
+
+		fresh := series.NewChapters(details, manifest.IsComplete)
+		out.report(event{Event: "series", Series: series.Title, Total: len(fresh), Dir: series.OutputDir})
+
+		if *dryRun {
+			for _, c := range fresh {
+				out.report(event{Event: "new", Series: series.Title, Chapter: c.Name, URL: c.URL})
+			}
+			continue
+		}
+
+		if len(fresh) > 0 {
+			succeeded := downloadAll(ctx, d, cfg, details, fresh, series.OutputDir, hist, out)
+			series.MarkKnown(succeeded...)
+			if len(succeeded) < len(fresh) {
+				code = ExitFailure
+			}
+		}
+		if ctx.Err() == nil {
+			series.LastChecked = time.Now()
+		}
+
+		// Save after every series so an interrupted update keeps its progress.
+		if err := lib.Save(); err != nil {
+			out.report(event{Event: "error", Error: err.Error()})
+			return ExitFailure
+		}
+	}
+	return code
+}
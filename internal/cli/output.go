@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"mangadl/internal/domain"
+)
+
+// event is a single progress line. In JSON mode it is printed as one object
+// per line; in text mode it is rendered as tab-separated fields.
+type event struct {
+	Event   string `json:"event"`
+	Series  string `json:"series,omitempty"`
+	Chapter string `json:"chapter,omitempty"`
+	Volume  string `json:"volume,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Dir     string `json:"dir,omitempty"`
+	Done    int    `json:"done,omitempty"`
+	Total   int    `json:"total,omitempty"`
+	Failed  int    `json:"failed,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// Selection summarises the chapters a --dry-run selected, e.g. "1-20, 25".
+	Selection string `json:"selection,omitempty"`
+
+	// Per-chapter results, set on "finished" and "failed"; Archive is also
+	// set on "volume".
+	Pages       int                 `json:"pages,omitempty"`
+	PagesDone   int                 `json:"pages_done,omitempty"`
+	FailedPages []domain.PageResult `json:"failed_pages,omitempty"`
+	Archive     string              `json:"archive,omitempty"`
+	Skipped     bool                `json:"skipped,omitempty"`
+	Retries     int                 `json:"retries,omitempty"`
+}
+
+// reporter serialises events from concurrent downloads onto one writer,
+// and copies them to the log.
+type reporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	asJSON bool
+	log    *slog.Logger
+}
+
+func newReporter(w io.Writer, asJSON bool, log *slog.Logger) *reporter {
+	return &reporter{w: w, asJSON: asJSON, log: log}
+}
+
+func (r *reporter) report(ev event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logEvent(ev)
+
+	if r.asJSON {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(r.w, "%s\n", data)
+		return
+	}
+
+	switch ev.Event {
+	case "series":
+		fmt.Fprintf(r.w, "series\t%s\t%d chapters\t%s\n", ev.Series, ev.Total, ev.Dir)
+	case "started":
+		fmt.Fprintf(r.w, "started\t%s\n", ev.Chapter)
+	case "finished":
+		status := fmt.Sprintf("%d/%d pages", ev.PagesDone, ev.Pages)
+		if ev.Skipped {
+			status = "already downloaded"
+		}
+		if ev.Retries > 0 {
+			status += fmt.Sprintf(", %d retries", ev.Retries)
+		}
+		fmt.Fprintf(r.w, "finished\t[%d/%d]\t%s\t%s\n", ev.Done, ev.Total, ev.Chapter, status)
+	case "failed":
+		fmt.Fprintf(r.w, "failed\t[%d/%d]\t%s\t%s\n", ev.Done, ev.Total, ev.Chapter, ev.Error)
+		for _, p := range ev.FailedPages {
+			fmt.Fprintf(r.w, "page-failed\t%s\t%d\t%s\t%d retries\t%s\n", ev.Chapter, p.Index, p.Error, p.Retries, p.URL)
+		}
+	case "volume":
+		if ev.Error != "" {
+			fmt.Fprintf(r.w, "volume-failed\t%s\t%s\n", ev.Volume, ev.Error)
+			break
+		}
+		fmt.Fprintf(r.w, "volume\t%s\t%d chapters\t%s\n", ev.Volume, ev.Total, ev.Archive)
+	case "selection":
+		fmt.Fprintf(r.w, "selection\t%s\t%d chapters\t%s\n", ev.Series, ev.Total, ev.Selection)
+	case "selected":
+		fmt.Fprintf(r.w, "selected\t%s\t%s\n", ev.Chapter, ev.URL)
+	case "new":
+		fmt.Fprintf(r.w, "new\t%s\t%s\n", ev.Chapter, ev.URL)
+	case "summary":
+		fmt.Fprintf(r.w, "summary\t%d ok\t%d failed\n", ev.Total-ev.Failed, ev.Failed)
+	case "error":
+		fmt.Fprintf(r.w, "error\t%s\n", ev.Error)
+	case "interrupted":
+		fmt.Fprintf(r.w, "interrupted\tprogress saved, run again to resume\n")
+	}
+}
+
+// logEvent writes ev to the log, as a warning if it carries an error.
+func (r *reporter) logEvent(ev event) {
+	if r.log == nil {
+		return
+	}
+	level := slog.LevelInfo
+	if ev.Error != "" {
+		level = slog.LevelWarn
+	}
+	var attrs []slog.Attr
+	add := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, slog.String(key, value))
+		}
+	}
+	add("series", ev.Series)
+	add("chapter", ev.Chapter)
+	add("volume", ev.Volume)
+	add("url", ev.URL)
+	add("dir", ev.Dir)
+	add("archive", ev.Archive)
+	add("error", ev.Error)
+	if ev.Total > 0 {
+		attrs = append(attrs, slog.Int("total", ev.Total))
+	}
+	if ev.Pages > 0 {
+		attrs = append(attrs, slog.Int("pages", ev.Pages), slog.Int("pages_done", ev.PagesDone))
+	}
+	if ev.Retries > 0 {
+		attrs = append(attrs, slog.Int("retries", ev.Retries))
+	}
+	r.log.LogAttrs(context.Background(), level, ev.Event, attrs...)
+}
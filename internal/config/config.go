@@ -1,24 +1,532 @@
 package config
 
-import "time"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-const (
-	// DefaultUserAgent used for all HTTP requests
-	DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
+	"mangadl/internal/pathtmpl"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes every environment variable read by Load, e.g.
+// MANGADL_OUTPUT_DIR or MANGADL_RETRY_ATTEMPTS.
+const EnvPrefix = "MANGADL_"
+
+// EnvConfigPath overrides the location of the config file.
+const EnvConfigPath = EnvPrefix + "CONFIG"
+
+// FileName is the config file inside the user config directory.
+const FileName = "config.yaml"
+
+// Config holds every user-tunable setting. It is built by Load from the
+// built-in defaults, the config file, the environment and finally CLI flags.
+type Config struct {
+	// UserAgent used for all HTTP requests
+	UserAgent string `yaml:"user_agent"`
 
 	// HTTP Timeouts
-	DefaultHTTPTimeout  = 60 * time.Second
-	DefaultHeadTimeout  = 10 * time.Second
-	DefaultChunkTimeout = 30 * time.Second
+	HTTPTimeout  time.Duration `yaml:"http_timeout"`
+	HeadTimeout  time.Duration `yaml:"head_timeout"`
+	ChunkTimeout time.Duration `yaml:"chunk_timeout"`
 
 	// Concurrency Limits
-	MaxChapterWorkers = 20
-	MaxImageWorkers   = 100
+	MaxChapterWorkers int `yaml:"max_chapter_workers"`
+	MaxSeriesWorkers  int `yaml:"max_series_workers"` // chapters of one series at once in the TUI queue; 0 for no limit
+	MaxImageWorkers   int `yaml:"max_image_workers"`
+	MaxConnsPerHost   int `yaml:"max_conns_per_host"`
 
 	// Chunk settings
-	MinChunkSize     = 100 * 1024 // 100KB
-	DefaultNumChunks = 4
+	MinChunkSize int64 `yaml:"min_chunk_size"`
+	NumChunks    int   `yaml:"num_chunks"`
 
 	// Directory settings
-	DefaultOutputDir = "output"
+	OutputDir string `yaml:"output_dir"`
+
+	// OutputFormat is the archive written for each chapter: "cbz", "epub"
+	// or "pdf".
+	OutputFormat string `yaml:"output_format"`
+
+	// Volumes merges chapters into volume archives; see ParseVolumes.
+	Volumes string `yaml:"volumes"`
+
+	// Templates name the directories, archives and pages written under
+	// OutputDir.
+	Templates TemplateConfig `yaml:"templates"`
+
+	Retry     RetryConfig     `yaml:"retry"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"` // applied to sources without their own limit
+	Convert   ConvertConfig   `yaml:"convert"`
+	Log       LogConfig       `yaml:"log"`
+
+	// Sources overrides the rate limit per source, keyed by source name
+	// (e.g. "MangaKatana"). Not settable from the environment.
+	Sources map[string]RateLimitConfig `yaml:"sources"`
+
+	// Devices adds or overrides e-reader profiles selectable with
+	// convert.device. Not settable from the environment.
+	Devices map[string]DeviceProfile `yaml:"devices"`
+}
+
+// RetryConfig controls retries of failed requests.
+type RetryConfig struct {
+	Attempts  int           `yaml:"attempts"` // total attempts, including the first
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+}
+
+// RateLimitConfig is a per-host token bucket. RPS 0 disables limiting.
+type RateLimitConfig struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+// LogConfig controls the log file, mangadl.log in the data directory
+// ($XDG_DATA_HOME/mangadl, see DataDir).
+type LogConfig struct {
+	MaxSize int64 `yaml:"max_size"` // bytes before the file is rotated; 0 disables the log file
+	Keep    int   `yaml:"keep"`     // rotated files kept next to it
+}
+
+// ConvertConfig controls processing of downloaded pages before they are
+// archived. The zero value, apart from Quality, keeps pages as downloaded.
+type ConvertConfig struct {
+	Format        string `yaml:"format"`         // "jpeg" or "png"; empty keeps the downloaded format
+	Quality       int    `yaml:"quality"`        // JPEG quality, 1-100
+	Grayscale     bool   `yaml:"grayscale"`      // convert pages to grayscale
+	StripMetadata bool   `yaml:"strip_metadata"` // re-encode pages even when nothing else changes
+	Device        string `yaml:"device"`         // e-reader profile, see DeviceProfiles
+	CropMargins   bool   `yaml:"crop_margins"`   // trim white borders
+	Spreads       string `yaml:"spreads"`        // landscape pages: "split", "rotate" or "" to keep
+}
+
+// Enabled reports whether pages need processing at all.
+func (c ConvertConfig) Enabled() bool {
+	return c.Format != "" || c.Grayscale || c.StripMetadata ||
+		c.Device != "" || c.CropMargins || c.Spreads != ""
+}
+
+// Spread handling modes for landscape pages.
+const (
+	SpreadsSplit  = "split"  // cut into two pages, right half first
+	SpreadsRotate = "rotate" // turn 90 degrees clockwise
+)
+
+// DeviceProfile describes an e-reader screen. Pages are downscaled to fit
+// Width x Height; MaxFileSize, if set, caps the size of each JPEG page.
+type DeviceProfile struct {
+	Width       int   `yaml:"width"`
+	Height      int   `yaml:"height"`
+	Grayscale   bool  `yaml:"grayscale"`
+	MaxFileSize int64 `yaml:"max_file_size"` // bytes, 0 for no limit
+}
+
+// DeviceProfiles are the built-in e-reader profiles.
+var DeviceProfiles = map[string]DeviceProfile{
+	"kindle":            {Width: 1072, Height: 1448, Grayscale: true, MaxFileSize: 1 << 20},
+	"kindle-paperwhite": {Width: 1236, Height: 1648, Grayscale: true, MaxFileSize: 1 << 20},
+	"kindle-oasis":      {Width: 1264, Height: 1680, Grayscale: true, MaxFileSize: 1 << 20},
+	"kindle-scribe":     {Width: 1860, Height: 2480, Grayscale: true, MaxFileSize: 2 << 20},
+	"kobo-clara":        {Width: 1072, Height: 1448, Grayscale: true},
+	"kobo-libra":        {Width: 1264, Height: 1680, Grayscale: true},
+	"kobo-libra-colour": {Width: 1264, Height: 1680},
+	"kobo-elipsa":       {Width: 1404, Height: 1872, Grayscale: true},
+}
+
+// Device returns the profile selected by convert.device, looking at the
+// user's profiles before the built-in ones. ok is false when no device is
+// selected.
+func (c Config) Device() (profile DeviceProfile, ok bool, err error) {
+	name := c.Convert.Device
+	if name == "" {
+		return DeviceProfile{}, false, nil
+	}
+	if p, found := c.Devices[name]; found {
+		return p, true, nil
+	}
+	if p, found := DeviceProfiles[name]; found {
+		return p, true, nil
+	}
+	return DeviceProfile{}, false, fmt.Errorf("unknown device %q (known: %s)", name, strings.Join(c.DeviceNames(), ", "))
+}
+
+// DeviceNames returns the names of all built-in and user profiles, sorted.
+func (c Config) DeviceNames() []string {
+	var names []string
+	for name := range DeviceProfiles {
+		names = append(names, name)
+	}
+	for name := range c.Devices {
+		if _, builtin := DeviceProfiles[name]; !builtin {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TemplateConfig holds the path templates; see pathtmpl.Template for the
+// syntax.
+type TemplateConfig struct {
+	Series  string `yaml:"series"`  // series directory, relative to output_dir
+	Chapter string `yaml:"chapter"` // chapter directory and archive, relative to the series directory
+	Page    string `yaml:"page"`    // page file name, without extension
+	Volume  string `yaml:"volume"`  // volume archive, relative to the series directory
+}
+
+// Templates are the parsed path templates.
+type Templates struct {
+	Series, Chapter, Page, Volume *pathtmpl.Template
+}
+
+// Placeholders available in each template.
+var (
+	SeriesPlaceholders  = []string{"series", "source"}
+	ChapterPlaceholders = append(slices.Clone(SeriesPlaceholders), "chapter", "chapter_number", "chapter_kind", "chapter_title", "volume")
+	PagePlaceholders    = append(slices.Clone(ChapterPlaceholders), "page")
+	VolumePlaceholders  = append(slices.Clone(SeriesPlaceholders), "volume", "volume_title")
 )
+
+// Parse parses and checks the path templates. Chapter and volume
+// templates must name the chapter or volume, and page templates the page
+// number, so that no two of them share a path.
+func (t TemplateConfig) Parse() (Templates, error) {
+	var (
+		out Templates
+		err error
+	)
+	if out.Series, err = pathtmpl.Parse(t.Series, SeriesPlaceholders...); err != nil {
+		return out, fmt.Errorf("templates.series: %w", err)
+	}
+	if out.Chapter, err = pathtmpl.Parse(t.Chapter, ChapterPlaceholders...); err != nil {
+		return out, fmt.Errorf("templates.chapter: %w", err)
+	}
+	if !out.Chapter.Has("chapter", "chapter_number", "chapter_title") {
+		return out, errors.New("templates.chapter must contain {chapter}, {chapter_number} or {chapter_title}")
+	}
+	if out.Page, err = pathtmpl.Parse(t.Page, PagePlaceholders...); err != nil {
+		return out, fmt.Errorf("templates.page: %w", err)
+	}
+	if !out.Page.Has("page") || strings.Contains(t.Page, "/") {
+		return out, errors.New("templates.page must contain {page} and no /")
+	}
+	if out.Volume, err = pathtmpl.Parse(t.Volume, VolumePlaceholders...); err != nil {
+		return out, fmt.Errorf("templates.volume: %w", err)
+	}
+	if !out.Volume.Has("volume", "volume_title") {
+		return out, errors.New("templates.volume must contain {volume} or {volume_title}")
+	}
+	return out, nil
+}
+
+// OutputFormats lists the supported archive formats.
+var OutputFormats = []string{"cbz", "epub", "pdf"}
+
+// ConvertFormats lists the formats pages can be converted to. Only formats
+// with a pure-Go encoder are supported.
+var ConvertFormats = []string{"jpeg", "png"}
+
+// Default returns the built-in defaults.
+func Default() Config {
+	return Config{
+		UserAgent:         "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+		HTTPTimeout:       60 * time.Second,
+		HeadTimeout:       10 * time.Second,
+		ChunkTimeout:      30 * time.Second,
+		MaxChapterWorkers: 10,
+		MaxImageWorkers:   100,
+		MaxConnsPerHost:   32,
+		MinChunkSize:      100 * 1024, // 100KB
+		NumChunks:         4,
+		OutputDir:         "output",
+		OutputFormat:      "cbz",
+		Retry: RetryConfig{
+			Attempts:  4,
+			BaseDelay: 500 * time.Millisecond,
+			MaxDelay:  30 * time.Second,
+		},
+		RateLimit: RateLimitConfig{RPS: 8, Burst: 16},
+		Convert:   ConvertConfig{Quality: 85},
+		Log:       LogConfig{MaxSize: 5 << 20, Keep: 3},
+		Templates: TemplateConfig{
+			Series:  "{series}",
+			Chapter: "{chapter}",
+			Page:    "{page:03}",
+			Volume:  "{volume_title}",
+		},
+	}
+}
+
+// Path returns the config file location: $MANGADL_CONFIG if set, otherwise
+// config.yaml in the user config directory ($XDG_CONFIG_HOME/mangadl).
+func Path() (string, error) {
+	if p := os.Getenv(EnvConfigPath); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mangadl", FileName), nil
+}
+
+// DataDir returns the directory for files mangadl writes as it runs, such
+// as the download history and the log: $XDG_DATA_HOME/mangadl, or
+// ~/.local/share/mangadl when XDG_DATA_HOME is unset or not absolute.
+func DataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "mangadl"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "mangadl"), nil
+}
+
+// Load builds the configuration from the defaults, the file at path (if it
+// exists; an empty path means Path()) and the environment. CLI flags are
+// applied on top by the caller.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		p, err := Path()
+		if err != nil {
+			return cfg, err
+		}
+		path = p
+	}
+	if err := cfg.loadFile(path); err != nil {
+		return cfg, err
+	}
+	if err := cfg.loadEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	cfg.OutputDir = expandHome(cfg.OutputDir)
+	cfg.Convert.Format = NormalizeFormat(cfg.Convert.Format)
+	cfg.OutputFormat = strings.ToLower(cfg.OutputFormat)
+	return cfg, cfg.Validate()
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides fields from MANGADL_* variables named after their YAML
+// keys, e.g. retry.attempts is read from MANGADL_RETRY_ATTEMPTS.
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	return setFromEnv(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookup)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setFromEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := setFromEnv(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports settings that would break downloads.
+func (c Config) Validate() error {
+	switch {
+	case c.OutputDir == "":
+		return errors.New("output_dir must not be empty")
+	case c.MaxChapterWorkers < 1:
+		return errors.New("max_chapter_workers must be at least 1")
+	case c.MaxSeriesWorkers < 0:
+		return errors.New("max_series_workers must not be negative")
+	case c.MaxImageWorkers < 1:
+		return errors.New("max_image_workers must be at least 1")
+	case c.MaxConnsPerHost < 1:
+		return errors.New("max_conns_per_host must be at least 1")
+	case c.NumChunks < 1:
+		return errors.New("num_chunks must be at least 1")
+	case c.Retry.Attempts < 1:
+		return errors.New("retry.attempts must be at least 1")
+	case c.RateLimit.RPS < 0 || c.RateLimit.Burst < 0:
+		return errors.New("rate_limit must not be negative")
+	case c.Log.MaxSize < 0 || c.Log.Keep < 0:
+		return errors.New("log.max_size and log.keep must not be negative")
+	case c.HTTPTimeout <= 0 || c.HeadTimeout <= 0 || c.ChunkTimeout <= 0:
+		return errors.New("timeouts must be positive")
+	case !slices.Contains(OutputFormats, c.OutputFormat):
+		return fmt.Errorf("output_format %q is not supported (supported: %s)",
+			c.OutputFormat, strings.Join(OutputFormats, ", "))
+	case c.Convert.Quality < 1 || c.Convert.Quality > 100:
+		return errors.New("convert.quality must be between 1 and 100")
+	case c.Convert.Format != "" && !slices.Contains(ConvertFormats, c.Convert.Format):
+		return fmt.Errorf("convert.format %q is not supported (supported: %s)",
+			c.Convert.Format, strings.Join(ConvertFormats, ", "))
+	case c.Convert.Spreads != "" && c.Convert.Spreads != SpreadsSplit && c.Convert.Spreads != SpreadsRotate:
+		return fmt.Errorf("convert.spreads must be %q or %q", SpreadsSplit, SpreadsRotate)
+	}
+	if _, err := c.Templates.Parse(); err != nil {
+		return err
+	}
+	if _, err := ParseVolumes(c.Volumes); err != nil {
+		return fmt.Errorf("volumes: %w", err)
+	}
+	if _, _, err := c.Device(); err != nil {
+		return fmt.Errorf("convert.device: %w", err)
+	}
+	for name, dev := range c.Devices {
+		if dev.Width < 1 || dev.Height < 1 || dev.MaxFileSize < 0 {
+			return fmt.Errorf("devices.%s: width and height must be positive", name)
+		}
+	}
+	for name, lim := range c.Sources {
+		if lim.RPS < 0 || lim.Burst < 0 {
+			return fmt.Errorf("sources.%s: rate limit must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// VolumesScraped groups chapters by the volume the source lists them under.
+const VolumesScraped = "scraped"
+
+// VolumeSpec describes how chapters are merged into volumes. At most one of
+// its fields is set; the zero value archives every chapter on its own.
+type VolumeSpec struct {
+	Size    int          // every Size consecutive chapters form a volume
+	Ranges  [][2]float64 // each inclusive chapter number range is a volume
+	Scraped bool         // group by the scraped volume of each chapter
+}
+
+// Enabled reports whether chapters are merged into volumes.
+func (v VolumeSpec) Enabled() bool {
+	return v.Size > 0 || len(v.Ranges) > 0 || v.Scraped
+}
+
+// ParseVolumes parses a volume spec: a chapter count ("10"), chapter number
+// ranges ("1-10,11-25.5") or "scraped". An empty spec disables volumes.
+func ParseVolumes(spec string) (VolumeSpec, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" {
+		return VolumeSpec{}, nil
+	}
+	if spec == VolumesScraped {
+		return VolumeSpec{Scraped: true}, nil
+	}
+	if size, err := strconv.Atoi(spec); err == nil {
+		if size < 1 {
+			return VolumeSpec{}, errors.New("chapter count must be at least 1")
+		}
+		return VolumeSpec{Size: size}, nil
+	}
+	var v VolumeSpec
+	for _, part := range strings.Split(spec, ",") {
+		lo, hi, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return VolumeSpec{}, fmt.Errorf("invalid range %q: want a chapter count, START-END ranges or %q", part, VolumesScraped)
+		}
+		start, err1 := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+		end, err2 := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+		if err1 != nil || err2 != nil || start > end {
+			return VolumeSpec{}, fmt.Errorf("invalid range %q", part)
+		}
+		v.Ranges = append(v.Ranges, [2]float64{start, end})
+	}
+	return v, nil
+}
+
+// NormalizeFormat lower-cases an image format name and maps "jpg" to "jpeg".
+func NormalizeFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+// expandHome replaces a leading "~/" with the user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	err := os.WriteFile(path, []byte(`
+output_dir: from-file
+max_chapter_workers: 3
+retry:
+  attempts: 2
+  base_delay: 2s
+sources:
+  MangaKatana:
+    rps: 1
+    burst: 2
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("MANGADL_OUTPUT_DIR", "from-env")
+	t.Setenv("MANGADL_RETRY_MAX_DELAY", "1m")
+	t.Setenv("MANGADL_RATE_LIMIT_RPS", "0.5")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	def := Default()
+	if cfg.OutputDir != "from-env" {
+		t.Errorf("OutputDir = %q; env should win over file", cfg.OutputDir)
+	}
+	if cfg.MaxChapterWorkers != 3 {
+		t.Errorf("MaxChapterWorkers = %d; want file value 3", cfg.MaxChapterWorkers)
+	}
+	if cfg.MaxImageWorkers != def.MaxImageWorkers {
+		t.Errorf("MaxImageWorkers = %d; want default %d", cfg.MaxImageWorkers, def.MaxImageWorkers)
+	}
+	if cfg.Retry.Attempts != 2 || cfg.Retry.BaseDelay != 2*time.Second || cfg.Retry.MaxDelay != time.Minute {
+		t.Errorf("Retry = %+v", cfg.Retry)
+	}
+	if cfg.RateLimit.RPS != 0.5 || cfg.RateLimit.Burst != def.RateLimit.Burst {
+		t.Errorf("RateLimit = %+v", cfg.RateLimit)
+	}
+	if lim := cfg.Sources["MangaKatana"]; lim.RPS != 1 || lim.Burst != 2 {
+		t.Errorf("Sources = %+v", cfg.Sources)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.OutputDir != Default().OutputDir {
+		t.Errorf("missing file should yield defaults, got %+v", cfg)
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	if dir, err := DataDir(); err != nil || dir != filepath.Join(data, "mangadl") {
+		t.Errorf("DataDir = %q, %v; want it under $XDG_DATA_HOME", dir, err)
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, env := range []string{"", "relative/dir"} {
+		t.Setenv("XDG_DATA_HOME", env)
+		if dir, err := DataDir(); err != nil || dir != filepath.Join(home, ".local", "share", "mangadl") {
+			t.Errorf("XDG_DATA_HOME=%q: DataDir = %q, %v; want ~/.local/share/mangadl", env, dir, err)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+	}{
+		{name: "unknown key", file: "outptu_dir: x\n"},
+		{name: "bad yaml", file: "output_dir: [\n"},
+		{name: "invalid value", file: "max_image_workers: 0\n"},
+		{name: "bad env", env: map[string]string{"MANGADL_HTTP_TIMEOUT": "soon"}},
+		{name: "avif target", file: "convert:\n  format: avif\n"},
+		{name: "unknown device", env: map[string]string{"MANGADL_CONVERT_DEVICE": "etch-a-sketch"}},
+		{name: "bad spreads", file: "convert:\n  spreads: fold\n"},
+		{name: "bad quality", env: map[string]string{"MANGADL_CONVERT_QUALITY": "0"}},
+		{name: "bad volumes", file: "volumes: 10-1\n"},
+		{name: "escaping template", file: "templates:\n  series: ../{series}\n"},
+		{name: "page template without page", env: map[string]string{"MANGADL_TEMPLATES_PAGE": "{chapter}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := Load(path); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
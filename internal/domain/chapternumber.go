@@ -0,0 +1,232 @@
+package domain
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ChapterKind classifies chapters that are not part of the main numbering.
+type ChapterKind int
+
+const (
+	KindRegular ChapterKind = iota
+	KindExtra               // extras, specials, side stories and bonus chapters
+	KindOmake
+	KindOneshot
+)
+
+var kindNames = []string{"regular", "extra", "omake", "oneshot"}
+
+// String returns the lower-case kind name, e.g. "omake".
+func (k ChapterKind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("ChapterKind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// MarshalText encodes the kind by name.
+func (k ChapterKind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+// UnmarshalText decodes a kind name.
+func (k *ChapterKind) UnmarshalText(text []byte) error {
+	for i, name := range kindNames {
+		if string(text) == name {
+			*k = ChapterKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown chapter kind %q", text)
+}
+
+// ChapterNumber is the position of a chapter parsed from its name, such as
+// volume 3, chapter 12, sub-part 5 for "Vol.3 Chapter 12.5".
+type ChapterNumber struct {
+	Volume   int         `json:"volume,omitempty"` // 0 when the name has no volume
+	Numbered bool        `json:"numbered"`         // the name has a chapter number
+	Main     int         `json:"main"`             // 12 in "12.5" and "12-2"
+	Sub      string      `json:"sub,omitempty"`    // digits after the main number: "5" in "12.5", "05" in "12.05", "2" in "12-2"; "" if none
+	Part     bool        `json:"part,omitempty"`   // Sub follows a '-' and is a part number rather than a decimal fraction
+	Kind     ChapterKind `json:"kind"`
+}
+
+// maxPart bounds the part numbers Float keeps in order; parts are mapped to
+// thousandths of the main number.
+const maxPart = 999
+
+var (
+	volumeNumberRegex  = regexp.MustCompile(`(?i)\bvol(?:ume)?\.?\s*(\d+)`)
+	chapterNumberRegex = regexp.MustCompile(`(?i)\b(?:chapter|chap|ch|episode|ep)\.?\s*(\d+)(?:([.-])(\d+))?`)
+	anyNumberRegex     = regexp.MustCompile(`(\d+)(?:([.-])(\d+))?`)
+
+	oneshotRegex = regexp.MustCompile(`(?i)\bone[- ]?shot\b`)
+	omakeRegex   = regexp.MustCompile(`(?i)\bomake\b`)
+	extraRegex   = regexp.MustCompile(`(?i)\b(?:extras?|specials?|side[- ]?stor(?:y|ies)|bonus)\b`)
+)
+
+// ParseChapterNumber parses a chapter name such as "Vol.3 Chapter 12.5",
+// "Ch. 10-2", "Chapter 40 (Omake)" or "Oneshot". The chapter number is the
+// one after "Chapter", "Ch." or "Episode", or else the first number that is
+// not the volume; "Vol.3 Extra" is an unnumbered extra of volume 3.
+func ParseChapterNumber(name string) ChapterNumber {
+	var n ChapterNumber
+	if m := volumeNumberRegex.FindStringSubmatchIndex(name); m != nil {
+		n.Volume, _ = strconv.Atoi(name[m[2]:m[3]])
+		name = name[:m[0]] + " " + name[m[1]:]
+	}
+
+	m := chapterNumberRegex.FindStringSubmatch(name)
+	if m == nil {
+		m = anyNumberRegex.FindStringSubmatch(name)
+	}
+	if m != nil {
+		main, err := strconv.Atoi(m[1])
+		if err == nil {
+			n.Numbered, n.Main = true, main
+			// "12.0" and "12-0" are chapter 12. Decimal digits are kept as
+			// written so "12.05" stays distinct from "12.5"; part numbers
+			// lose their leading zeros, so "12-02" is part 2.
+			if m[2] == "-" {
+				n.Sub = strings.TrimLeft(m[3], "0")
+				n.Part = n.Sub != ""
+			} else if strings.Trim(m[3], "0") != "" {
+				n.Sub = m[3]
+			}
+		}
+	}
+
+	switch {
+	case oneshotRegex.MatchString(name):
+		n.Kind = KindOneshot
+	case omakeRegex.MatchString(name):
+		n.Kind = KindOmake
+	case extraRegex.MatchString(name):
+		n.Kind = KindExtra
+	}
+	return n
+}
+
+// Float returns the number as a decimal for matching against ranges: 12.5
+// for "12.5", and for parts the main number plus the part in thousandths,
+// 12.002 for "12-2" and 12.01 for "12-10", so parts keep their order and do
+// not take the value of a decimal such as 12.1. Parts above maxPart count as
+// maxPart. Unnumbered chapters return 0.
+func (n ChapterNumber) Float() float64 {
+	if !n.Numbered {
+		return 0
+	}
+	if n.Part {
+		return float64(n.Main) + float64(n.part())/(maxPart+1)
+	}
+	f, _ := strconv.ParseFloat(n.String(), 64)
+	return f
+}
+
+// part returns the part number, at most maxPart.
+func (n ChapterNumber) part() int {
+	if len(n.Sub) > len(strconv.Itoa(maxPart)) {
+		return maxPart
+	}
+	p, _ := strconv.Atoi(n.Sub)
+	return min(p, maxPart)
+}
+
+// String returns the number as "12", "12.5" or "12-2", or "" when
+// unnumbered.
+func (n ChapterNumber) String() string { return n.Pad(0) }
+
+// Pad returns String with the main number zero-padded to width digits.
+func (n ChapterNumber) Pad(width int) string {
+	if !n.Numbered {
+		return ""
+	}
+	s := fmt.Sprintf("%0*d", width, n.Main)
+	switch {
+	case n.Part:
+		s += "-" + n.Sub
+	case n.Sub != "":
+		s += "." + n.Sub
+	}
+	return s
+}
+
+// Compare orders chapter numbers for reading: by volume, with chapters
+// not yet collected in a volume last; then numbered chapters by main
+// number and sub-part, followed by unnumbered ones; then by kind, so an
+// extra follows the regular chapter with the same number. Decimal sub-parts
+// compare as fractions, so 12.25 comes before 12.5, and parts as integers,
+// so 12-2 comes before 12-10; between the two, parts take their Float
+// value. It returns -1, 0 or +1.
+func (n ChapterNumber) Compare(o ChapterNumber) int {
+	volume := func(v int) int {
+		if v == 0 {
+			return int(^uint(0) >> 1)
+		}
+		return v
+	}
+	if c := cmp.Compare(volume(n.Volume), volume(o.Volume)); c != 0 {
+		return c
+	}
+	if n.Numbered != o.Numbered {
+		if n.Numbered {
+			return -1
+		}
+		return 1
+	}
+	if c := cmp.Compare(n.Main, o.Main); c != 0 {
+		return c
+	}
+	if c := n.compareSub(o); c != 0 {
+		return c
+	}
+	return cmp.Compare(n.Kind, o.Kind)
+}
+
+// compareSub compares the sub-parts of two numbers with the same main
+// number.
+func (n ChapterNumber) compareSub(o ChapterNumber) int {
+	switch {
+	case n.Part && o.Part:
+		// Leading zeros are trimmed, so longer means larger.
+		if c := cmp.Compare(len(n.Sub), len(o.Sub)); c != 0 {
+			return c
+		}
+		return strings.Compare(n.Sub, o.Sub)
+	case n.Part != o.Part:
+		a, b := n.fraction(), o.fraction()
+		if c := compareFraction(a, b); c != 0 {
+			return c
+		}
+		// The same value: the decimal first.
+		if n.Part {
+			return 1
+		}
+		return -1
+	}
+	return compareFraction(n.Sub, o.Sub)
+}
+
+// fraction returns the decimal digits of the sub-part as Float sees it:
+// "002" for part 2.
+func (n ChapterNumber) fraction() string {
+	if n.Part {
+		return fmt.Sprintf("%0*d", len(strconv.Itoa(maxPart)), n.part())
+	}
+	return n.Sub
+}
+
+// compareFraction compares the digit strings after a decimal point by
+// value, padding the shorter one with zeros. Equal values written with a
+// different number of digits, "5" and "50", are ordered shortest first.
+func compareFraction(a, b string) int {
+	width := max(len(a), len(b))
+	pa := a + strings.Repeat("0", width-len(a))
+	pb := b + strings.Repeat("0", width-len(b))
+	if c := strings.Compare(pa, pb); c != 0 {
+		return c
+	}
+	return cmp.Compare(len(a), len(b))
+}
@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestParseChapterNumber(t *testing.T) {
+	tests := []struct {
+		name string
+		want ChapterNumber
+	}{
+		{"Chapter 12", ChapterNumber{Numbered: true, Main: 12}},
+		{"Chapter 0: Prologue", ChapterNumber{Numbered: true}},
+		{"Vol.3 Chapter 12.5", ChapterNumber{Volume: 3, Numbered: true, Main: 12, Sub: "5"}},
+		{"Chapter 12.05", ChapterNumber{Numbered: true, Main: 12, Sub: "05"}},
+		{"Chapter 12.25", ChapterNumber{Numbered: true, Main: 12, Sub: "25"}},
+		{"Chapter 12.0", ChapterNumber{Numbered: true, Main: 12}},
+		{"Volume 2 Ch. 10-2", ChapterNumber{Volume: 2, Numbered: true, Main: 10, Sub: "2", Part: true}},
+		{"Chapter 10-10", ChapterNumber{Numbered: true, Main: 10, Sub: "10", Part: true}},
+		{"Chapter 10-02", ChapterNumber{Numbered: true, Main: 10, Sub: "2", Part: true}},
+		{"Chapter 10-0", ChapterNumber{Numbered: true, Main: 10}},
+		{"Chapter 10 - The Bet", ChapterNumber{Numbered: true, Main: 10}},
+		{"One Piece 1000", ChapterNumber{Numbered: true, Main: 1000}},
+		{"Vol.3 Extra", ChapterNumber{Volume: 3, Kind: KindExtra}},
+		{"Extra", ChapterNumber{Kind: KindExtra}},
+		{"Chapter 40 (Omake)", ChapterNumber{Numbered: true, Main: 40, Kind: KindOmake}},
+		{"Side Story 2", ChapterNumber{Numbered: true, Main: 2, Kind: KindExtra}},
+		{"Oneshot", ChapterNumber{Kind: KindOneshot}},
+		{"Episode 7", ChapterNumber{Numbered: true, Main: 7}},
+	}
+	for _, tt := range tests {
+		if got := ParseChapterNumber(tt.name); got != tt.want {
+			t.Errorf("ParseChapterNumber(%q) = %+v; want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChapterNumberCompare(t *testing.T) {
+	names := []string{
+		"Chapter 11",
+		"Vol.2 Chapter 1",
+		"Oneshot",
+		"Chapter 10.5",
+		"Vol.1 Extra",
+		"Chapter 10 Omake",
+		"Vol.1 Chapter 2",
+		"Chapter 10",
+		"Vol.1 Chapter 1.10",
+		"Vol.1 Chapter 1.9",
+		"Chapter 12.5",
+		"Chapter 12.25",
+		"Chapter 12.05",
+		"Chapter 13-10",
+		"Chapter 13.1",
+		"Chapter 13-2",
+	}
+	want := []string{
+		"Vol.1 Chapter 1.10",
+		"Vol.1 Chapter 1.9",
+		"Vol.1 Chapter 2",
+		"Vol.1 Extra",
+		"Vol.2 Chapter 1",
+		"Chapter 10",
+		"Chapter 10 Omake",
+		"Chapter 10.5",
+		"Chapter 11",
+		"Chapter 12.05",
+		"Chapter 12.25",
+		"Chapter 12.5",
+		"Chapter 13-2",
+		"Chapter 13-10",
+		"Chapter 13.1",
+		"Oneshot",
+	}
+	slices.SortStableFunc(names, func(a, b string) int {
+		return ParseChapterNumber(a).Compare(ParseChapterNumber(b))
+	})
+	if !slices.Equal(names, want) {
+		t.Errorf("sorted = %q\nwant %q", names, want)
+	}
+}
+
+func TestChapterNumberFormat(t *testing.T) {
+	n := ParseChapterNumber("Chapter 7-2")
+	if got := n.String(); got != "7-2" {
+		t.Errorf("String = %q", got)
+	}
+	if got := n.Pad(3); got != "007-2" {
+		t.Errorf("Pad(3) = %q", got)
+	}
+	if got := n.Float(); got != 7.002 {
+		t.Errorf("Float = %v", got)
+	}
+	// Parts keep their order as floats and do not collide with decimals.
+	part2, part10, dec := ParseChapterNumber("Chapter 10-2"), ParseChapterNumber("Chapter 10-10"), ParseChapterNumber("Chapter 10.1")
+	if !(part2.Float() < part10.Float() && part10.Float() < dec.Float()) {
+		t.Errorf("Float of 10-2, 10-10, 10.1 = %v, %v, %v; want increasing", part2.Float(), part10.Float(), dec.Float())
+	}
+	for _, name := range []string{"12.05", "12.25", "12.5"} {
+		n := ParseChapterNumber("Chapter " + name)
+		if got := n.String(); got != name {
+			t.Errorf("String of %s = %q", name, got)
+		}
+		if got, want := n.Float(), map[string]float64{"12.05": 12.05, "12.25": 12.25, "12.5": 12.5}[name]; got != want {
+			t.Errorf("Float of %s = %v; want %v", name, got, want)
+		}
+	}
+	if got := ParseChapterNumber("Extra").String(); got != "" {
+		t.Errorf("unnumbered String = %q; want empty", got)
+	}
+
+	data, err := json.Marshal(ParseChapterNumber("Chapter 3 Omake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back ChapterNumber
+	if err := json.Unmarshal(data, &back); err != nil || back.Kind != KindOmake || back.Main != 3 {
+		t.Errorf("round trip of %s = %+v, %v", data, back, err)
+	}
+}
@@ -1,10 +1,33 @@
 package domain
 
+import "fmt"
+
 // Chapter represents a manga chapter.
 type Chapter struct {
-	Name string
-	URL  string
-	ID   int // preserve original index
+	Name   string
+	URL    string
+	ID     string        // stable identity within the series, see scraper.AssignChapterIDs
+	Index  int           // position in the source's chapter list
+	Number ChapterNumber // parsed from the name
+	Volume string        // volume as listed by the source, if any
+}
+
+// Key returns the chapter ID, or the URL for chapters built without one.
+// Download state and library records are keyed by it.
+func (c Chapter) Key() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return c.URL
+}
+
+// ParsedNumber returns Number, parsing it from the name for chapters built
+// without one.
+func (c Chapter) ParsedNumber() ChapterNumber {
+	if c.Number == (ChapterNumber{}) {
+		return ParseChapterNumber(c.Name)
+	}
+	return c.Number
 }
 
 // Title returns the chapter name for the list interface.
@@ -21,13 +44,14 @@ type MangaDetails struct {
 	Title    string
 	Chapters []Chapter
 	CoverURL string
-}
+	URL      string // series page the details were fetched from
+	Source   string // name of the site that produced them
 
-// ChunkDownload represents a downloaded chunk of a file.
-type ChunkDownload struct {
-	Data  []byte
-	Start int64
-	End   int64
+	// Optional metadata, filled in when the source exposes it.
+	Summary  string
+	Authors  []string
+	Genres   []string
+	Language string // ISO 639-1 code, e.g. "en"
 }
 
 // DownloadStatus represents the current state of a download.
@@ -39,3 +63,63 @@ const (
 	StatusCompleted
 	StatusFailed
 )
+
+// PageResult is the outcome of downloading one page.
+type PageResult struct {
+	Index      int    `json:"page"` // 1-based page number
+	URL        string `json:"url"`
+	StatusCode int    `json:"status,omitempty"` // HTTP status of the failing response, if any
+	Retries    int    `json:"retries,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Format     string `json:"format,omitempty"` // detected image format, e.g. "png"
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+}
+
+// ChapterProgress reports a chapter download while its pages arrive.
+type ChapterProgress struct {
+	PagesDone  int     // pages on disk, including those kept from earlier runs
+	PagesTotal int     // 0 until the page list is known
+	Bytes      int64   // image bytes received so far by this attempt
+	Speed      float64 // recent bytes per second
+}
+
+// Volume is a group of consecutive chapters archived together.
+type Volume struct {
+	Title    string
+	Number   int // 0 when the group is not a numbered volume
+	Chapters []Chapter
+}
+
+// ChapterResult summarises a chapter download.
+type ChapterResult struct {
+	Chapter   Chapter      `json:"chapter"`
+	Total     int          `json:"total_pages"`
+	Reused    int          `json:"reused_pages"`    // already on disk from an earlier run
+	Attempted int          `json:"attempted_pages"` // fetched during this run
+	Succeeded int          `json:"succeeded_pages"` // of the attempted pages
+	Pages     []PageResult `json:"pages,omitempty"` // every page in reading order
+	Failed    []PageResult `json:"failed_pages,omitempty"`
+	Retries   int          `json:"retries,omitempty"` // summed over all pages
+	Bytes     int64        `json:"bytes,omitempty"`   // image data received during this run
+	Archive   string       `json:"archive,omitempty"` // written only when every page succeeded
+	Skipped   bool         `json:"skipped,omitempty"` // archive already existed
+}
+
+// PagesDone returns the number of pages available on disk.
+func (r ChapterResult) PagesDone() int { return r.Reused + r.Succeeded }
+
+// Summary returns a short human-readable description of the result.
+func (r ChapterResult) Summary() string {
+	if r.Skipped {
+		return "already downloaded"
+	}
+	s := fmt.Sprintf("%d/%d pages", r.PagesDone(), r.Total)
+	if len(r.Failed) > 0 {
+		s += fmt.Sprintf(", %d failed", len(r.Failed))
+	}
+	if r.Retries > 0 {
+		s += fmt.Sprintf(", %d retries", r.Retries)
+	}
+	return s
+}
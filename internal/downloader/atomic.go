@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// verifyZip checks that the zip archive in f holds exactly the entries
+// named in want, in that order, and that every entry reads back with a
+// matching checksum.
+func verifyZip(f *os.File, want []string) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := zip.NewReader(f, st.Size())
+	if err != nil {
+		return fmt.Errorf("verify archive: %w", err)
+	}
+	if len(r.File) != len(want) {
+		return fmt.Errorf("verify archive: %d entries, want %d", len(r.File), len(want))
+	}
+	for i, zf := range r.File {
+		if zf.Name != want[i] {
+			return fmt.Errorf("verify archive: entry %d is %s, want %s", i+1, zf.Name, want[i])
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("verify archive: %s: %w", zf.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("verify archive: %s: %w", zf.Name, err)
+		}
+	}
+	return nil
+}
+
+// cleanStale removes what an interrupted run left in mangaDir: partial
+// files and the staging directory. Complete files are never touched.
+func cleanStale(mangaDir string) error {
+	if err := os.RemoveAll(filepath.Join(mangaDir, stageDirName)); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(mangaDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), partSuffix) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package downloader
+
+import (
+	"time"
+
+	"mangadl/internal/domain"
+)
+
+// book is the content of an EPUB or PDF: metadata plus one or more sections,
+// each listed in the table of contents.
+type book struct {
+	ID       string
+	Title    string
+	Series   string
+	Language string
+	Summary  string
+	Authors  []string
+	Genres   []string
+	Modified time.Time
+	Sections []bookSection
+}
+
+// bookSection is a run of pages starting a table of contents entry, such as
+// a chapter.
+type bookSection struct {
+	Title string
+	Dir   string // directory holding the page files
+	Pages []PageState
+}
+
+// newChapterBook builds the book for a single chapter whose pages are in dir.
+func newChapterBook(manga *domain.MangaDetails, chapter domain.Chapter, dir string, pages []PageState) *book {
+	b := &book{
+		ID:       chapter.URL,
+		Title:    chapter.Name,
+		Modified: time.Now().UTC(),
+		Sections: []bookSection{{Title: chapter.Name, Dir: dir, Pages: pages}},
+	}
+	if manga != nil {
+		b.Title = manga.Title + " - " + chapter.Name
+		b.Series = manga.Title
+		b.Language = manga.Language
+		b.Summary = manga.Summary
+		b.Authors = manga.Authors
+		b.Genres = manga.Genres
+	}
+	return b
+}
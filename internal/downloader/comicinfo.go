@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+
+	"mangadl/internal/domain"
+)
+
+// ComicInfoName is the metadata file name readers such as Komga, Kavita and
+// Calibre look for at the root of a CBZ.
+const ComicInfoName = "ComicInfo.xml"
+
+// ComicInfo is the subset of the ComicInfo v2.0 schema we can fill in.
+type ComicInfo struct {
+	XMLName     xml.Name        `xml:"ComicInfo"`
+	XMLNSXSI    string          `xml:"xmlns:xsi,attr"`
+	XMLNSXSD    string          `xml:"xmlns:xsd,attr"`
+	Title       string          `xml:"Title,omitempty"`
+	Series      string          `xml:"Series,omitempty"`
+	Number      string          `xml:"Number,omitempty"`
+	Volume      int             `xml:"Volume,omitempty"`
+	Summary     string          `xml:"Summary,omitempty"`
+	Writer      string          `xml:"Writer,omitempty"`
+	Genre       string          `xml:"Genre,omitempty"`
+	Web         string          `xml:"Web,omitempty"`
+	PageCount   int             `xml:"PageCount,omitempty"`
+	LanguageISO string          `xml:"LanguageISO,omitempty"`
+	Manga       string          `xml:"Manga,omitempty"`
+	Pages       []ComicPageInfo `xml:"Pages>Page,omitempty"`
+}
+
+// ComicPageInfo describes one page of the archive.
+type ComicPageInfo struct {
+	Image     int    `xml:"Image,attr"`
+	Type      string `xml:"Type,attr,omitempty"`
+	ImageSize int64  `xml:"ImageSize,attr,omitempty"`
+	Bookmark  string `xml:"Bookmark,attr,omitempty"`
+}
+
+var chapterPrefixRegex = regexp.MustCompile(`(?i)^\s*(?:vol(?:ume)?\.?\s*\d+\s*)?(?:chapter|ch\.?)\s*\d+(?:\.\d+)?\s*[:\-–]?\s*`)
+
+// NewComicInfo builds the metadata for a chapter archive. pages lists the
+// archived pages in reading order.
+func NewComicInfo(manga *domain.MangaDetails, chapter domain.Chapter, pages []PageState) *ComicInfo {
+	info := newComicInfo(manga)
+	info.Title = chapterTitle(chapter.Name)
+	info.Number = chapter.ParsedNumber().String()
+	info.Web = chapter.URL
+	info.PageCount = len(pages)
+	for i, p := range pages {
+		page := ComicPageInfo{Image: i, ImageSize: p.Size}
+		if i == 0 {
+			page.Type = "FrontCover"
+		}
+		info.Pages = append(info.Pages, page)
+	}
+	return info
+}
+
+// NewVolumeComicInfo builds the metadata for a volume archive. The first
+// page of each chapter section is bookmarked with the chapter name.
+func NewVolumeComicInfo(manga *domain.MangaDetails, vol domain.Volume, sections []bookSection) *ComicInfo {
+	info := newComicInfo(manga)
+	info.Title = vol.Title
+	info.Volume = vol.Number
+	var urls []string
+	for _, c := range vol.Chapters {
+		urls = append(urls, c.URL)
+	}
+	info.Web = strings.Join(urls, " ")
+	for _, section := range sections {
+		for i, p := range section.Pages {
+			page := ComicPageInfo{Image: len(info.Pages), ImageSize: p.Size}
+			if len(info.Pages) == 0 {
+				page.Type = "FrontCover"
+			}
+			if i == 0 {
+				page.Bookmark = section.Title
+			}
+			info.Pages = append(info.Pages, page)
+		}
+	}
+	info.PageCount = len(info.Pages)
+	return info
+}
+
+// newComicInfo fills in the series-level metadata shared by chapter and
+// volume archives.
+func newComicInfo(manga *domain.MangaDetails) *ComicInfo {
+	info := &ComicInfo{
+		XMLNSXSI: "http://www.w3.org/2001/XMLSchema-instance",
+		XMLNSXSD: "http://www.w3.org/2001/XMLSchema",
+		Manga:    "YesAndRightToLeft",
+	}
+	if manga != nil {
+		info.Series = manga.Title
+		info.Summary = manga.Summary
+		info.Writer = strings.Join(manga.Authors, ", ")
+		info.Genre = strings.Join(manga.Genres, ", ")
+		info.LanguageISO = manga.Language
+	}
+	return info
+}
+
+// Marshal renders the metadata as an XML document.
+func (c *ComicInfo) Marshal() ([]byte, error) {
+	data, err := xml.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// chapterTitle strips the "Chapter N:" prefix from a chapter name, keeping
+// the full name when nothing but the number would remain.
+func chapterTitle(name string) string {
+	title := strings.TrimSpace(chapterPrefixRegex.ReplaceAllString(name, ""))
+	if title == "" {
+		return strings.TrimSpace(name)
+	}
+	return title
+}
@@ -2,91 +2,375 @@ package downloader
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strconv"
 	"sync"
+	"time"
 
+	"mangadl/internal/atomicfile"
 	"mangadl/internal/config"
 	"mangadl/internal/domain"
+	"mangadl/internal/ratelimit"
+	"mangadl/internal/retry"
 	"mangadl/internal/scraper"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/valyala/fasthttp"
 )
 
-var (
-	fasthttpClient *fasthttp.Client
-	imageSemaphore = make(chan struct{}, config.MaxImageWorkers)
-)
+// Downloader fetches chapter images and packages them into archives. It is
+// safe for concurrent use; the image worker limit is shared by all chapters.
+type Downloader struct {
+	cfg            config.Config
+	scraper        *scraper.Scraper
+	client         *fasthttp.Client
+	imageSemaphore chan struct{}
+	retry          retry.Policy
+	pipeline       *pipeline // nil when pages are archived as downloaded
+	templates      config.Templates
+}
 
-func init() {
-	fasthttpClient = &fasthttp.Client{MaxConnsPerHost: 1000}
+// New returns a downloader that resolves chapters with s and shares its
+// rate limiter. cfg is expected to have passed Validate.
+func New(cfg config.Config, s *scraper.Scraper) *Downloader {
+	// Validate has already rejected unknown devices and bad templates.
+	p, _ := newPipeline(cfg)
+	templates, _ := cfg.Templates.Parse()
+	return &Downloader{
+		cfg:            cfg,
+		scraper:        s,
+		client:         &fasthttp.Client{MaxConnsPerHost: cfg.MaxConnsPerHost},
+		imageSemaphore: make(chan struct{}, cfg.MaxImageWorkers),
+		retry:          retry.NewPolicy(cfg.Retry),
+		pipeline:       p,
+		templates:      templates,
+	}
 }
 
+// stageDirName is the directory, inside the manga directory, where processed
+// pages are assembled before archiving.
+const stageDirName = ".mangadl-stage"
+
+// ErrIncomplete is returned by DownloadChapter when some pages could not be
+// downloaded. No archive is written in that case.
+var ErrIncomplete = errors.New("chapter incomplete")
+
 // DownloadChapter handles the full download process for a single chapter.
-func DownloadChapter(chapterURL, chapterName, mangaDir string) error {
-	safeName := SanitizeFilename(chapterName)
-	outputDir := filepath.Join(config.DefaultOutputDir, mangaDir, safeName)
+// mangaDir is the directory the chapter archive is written to. Progress is
+// recorded in the manga directory's manifest, so a rerun skips chapters that
+// are already archived and only fetches pages that are missing or truncated.
+// The archive is a CBZ with a ComicInfo.xml, an EPUB or a PDF, depending on
+// the configured output format, with metadata taken from manga and chapter.
+//
+// The returned result is non-nil whenever the page list could be fetched,
+// even if the download failed, so callers can report which pages are missing.
+// When ctx is cancelled, requests in flight are abandoned, the pages saved
+// so far are recorded in the manifest for the next run, and ctx's error is
+// returned.
+func (d *Downloader) DownloadChapter(ctx context.Context, manga *domain.MangaDetails, chapter domain.Chapter, mangaDir string) (*domain.ChapterResult, error) {
+	manifest, err := LoadManifest(mangaDir)
+	if err != nil {
+		return nil, err
+	}
+	chapterDir, err := d.chapterDir(manifest, manga, chapter)
+	if err != nil {
+		return nil, err
+	}
+	outputDir, err := joinWithin(mangaDir, chapterDir)
+	if err != nil {
+		return nil, err
+	}
+	archiveName := chapterDir + "." + d.cfg.OutputFormat
 
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return err
+	// A chapter archived in another format is rebuilt from its pages.
+	result, _, err := d.fetchChapter(ctx, manga, chapter, mangaDir, func(archive string) bool { return archive == archiveName })
+	if err != nil || result.Skipped {
+		return result, err
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	state, _ := manifest.Chapter(chapter)
+
+	// Processed pages are staged next to the downloads, which stay as
+	// fetched so the manifest keeps verifying them.
+	archiveDir, archivePages := outputDir, state.Pages
+	if d.pipeline != nil {
+		archiveDir = filepath.Join(mangaDir, stageDirName, filepath.FromSlash(chapterDir))
+		defer os.RemoveAll(archiveDir)
+		if archivePages, err = d.stagePages(outputDir, archiveDir, state.Pages); err != nil {
+			return result, err
+		}
+	}
+
+	archivePath := filepath.Join(mangaDir, archiveName)
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return result, err
+	}
+	if err := d.writeArchive(archiveDir, archivePath, manga, chapter, archivePages); err != nil {
+		return result, fmt.Errorf("failed to write archive: %w", err)
 	}
+	result.Archive = archivePath
+	return result, manifest.MarkComplete(chapter, archiveName)
+}
 
-	doc, err := fetchPage(chapterURL)
+// FetchChapter downloads and verifies the pages of a chapter without
+// archiving it, for chapters that are archived as part of a volume. Chapters
+// already archived in their volume are skipped; one archived on its own is
+// fetched again, so its volume archive gets written.
+func (d *Downloader) FetchChapter(ctx context.Context, manga *domain.MangaDetails, chapter domain.Chapter, mangaDir string) (*domain.ChapterResult, error) {
+	volumeArchive, err := d.chapterVolumeArchive(manga, chapter)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	result, _, err := d.fetchChapter(ctx, manga, chapter, mangaDir, func(archive string) bool {
+		return volumeArchive != "" && archive == volumeArchive
+	})
+	return result, err
+}
 
-	imageURLs := scraper.ExtractImageURLs(doc)
-	if len(imageURLs) == 0 {
-		return fmt.Errorf("no images found")
+// fetchChapter downloads the missing pages of a chapter. It skips the chapter
+// when it is complete and archived(archive) accepts its archive name.
+func (d *Downloader) fetchChapter(ctx context.Context, manga *domain.MangaDetails, chapter domain.Chapter, mangaDir string, archived func(archive string) bool) (*domain.ChapterResult, *Manifest, error) {
+	result := &domain.ChapterResult{Chapter: chapter}
+	manifest, err := LoadManifest(mangaDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if state, _ := manifest.Chapter(chapter); manifest.IsComplete(chapter) && archived(state.Archive) {
+		result.Total = len(state.Pages)
+		result.Reused = len(state.Pages)
+		result.Archive = filepath.Join(mangaDir, state.Archive)
+		result.Pages = pageResults(state.Pages, nil, nil)
+		result.Skipped = true
+		return result, manifest, nil
 	}
 
-	if err := downloadImagesChunked(imageURLs, outputDir); err != nil {
-		return err
+	chapterDir, err := d.chapterDir(manifest, manga, chapter)
+	if err != nil {
+		return nil, nil, err
+	}
+	outputDir, err := joinWithin(mangaDir, chapterDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	state, ok := manifest.Chapter(chapter)
+	var pending []int
+	for i, page := range state.Pages {
+		if !verifyPage(outputDir, page) {
+			pending = append(pending, i)
+		}
+	}
+	// Image URLs are often signed and expire, so the page list is fetched
+	// again whenever pages are left to download, not only the first time.
+	if !ok || len(state.Pages) == 0 || len(pending) > 0 {
+		imageURLs, err := d.scraper.FetchPageURLs(ctx, chapter.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(imageURLs) == 0 {
+			return nil, nil, fmt.Errorf("no images found")
+		}
+		manifest.SetPages(chapter, mergePages(state.Pages, pending, imageURLs))
+		state, _ = manifest.Chapter(chapter)
+		pending = nil
+		for i, page := range state.Pages {
+			if !page.Done {
+				pending = append(pending, i)
+			}
+		}
 	}
+	result.Total = len(state.Pages)
+	result.Reused = len(state.Pages) - len(pending)
+	result.Attempted = len(pending)
 
-	zipName := filepath.Join(config.DefaultOutputDir, mangaDir, safeName+".cbz")
-	return createCBZ(outputDir, zipName)
+	ctx, progress := startProgress(ctx, result.Total, result.Reused)
+	opts := d.scraper.Options(chapter.URL)
+	markPage := func(idx int, page PageState) {
+		// Saving is throttled and retried by the Save below, so a failed
+		// intermediate save only costs resume granularity.
+		_ = manifest.MarkPage(chapter, idx, page)
+		progress.pageDone()
+	}
+	names := make([]string, len(state.Pages))
+	for _, idx := range pending {
+		if names[idx], err = d.pageBase(manga, chapter, idx+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	attempted := d.downloadImagesChunked(ctx, state.Pages, pending, names, outputDir, opts, markPage)
+	result.Bytes = progress.bytes()
+	for _, page := range attempted {
+		result.Retries += page.Retries
+		if page.Error != "" {
+			result.Failed = append(result.Failed, page)
+		} else {
+			result.Succeeded++
+		}
+	}
+	if err := manifest.Save(); err != nil {
+		return result, manifest, err
+	}
+
+	state, _ = manifest.Chapter(chapter)
+	result.Pages = pageResults(state.Pages, pending, attempted)
+
+	if err := ctx.Err(); err != nil {
+		return result, manifest, err
+	}
+	if len(result.Failed) > 0 {
+		return result, manifest, fmt.Errorf("%w: %d of %d pages failed (first: page %d: %s)",
+			ErrIncomplete, len(result.Failed), result.Total, result.Failed[0].Index, result.Failed[0].Error)
+	}
+	return result, manifest, nil
 }
 
-// fetchPage is a local helper, duplicated from scraper to avoid circular dependency if needed,
-// or better yet, just use http client here as well.
-// For simplicity, let's just reuse the logic but with standard http client for the page itself.
-func fetchPage(url string) (*goquery.Document, error) {
-	client := &http.Client{Timeout: config.DefaultHTTPTimeout}
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("User-Agent", config.DefaultUserAgent)
-	resp, err := client.Do(req)
-	if err != nil {
+// mergePages builds the page list for imageURLs, keeping the pages of old
+// that were downloaded and verified, that is those at an index not in
+// pending, so they are not fetched again.
+func mergePages(old []PageState, pending []int, imageURLs []string) []PageState {
+	pages := make([]PageState, len(imageURLs))
+	for i, u := range imageURLs {
+		if i < len(old) && !slices.Contains(pending, i) {
+			pages[i] = old[i]
+		}
+		pages[i].URL = u
+	}
+	return pages
+}
+
+// stagePages writes the pages in srcDir to destDir as they go into the
+// archive: processed by the pipeline if one is configured, copied otherwise.
+func (d *Downloader) stagePages(srcDir, destDir string, pages []PageState) ([]PageState, error) {
+	if d.pipeline != nil {
+		staged, err := d.pipeline.processPages(srcDir, destDir, pages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process pages: %w", err)
+		}
+		return staged, nil
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	return goquery.NewDocumentFromReader(resp.Body)
+	for _, page := range pages {
+		if err := copyFile(filepath.Join(srcDir, page.File), filepath.Join(destDir, page.File)); err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
 }
 
-func downloadImagesChunked(imageURLs []string, outputDir string) error {
+// copyFile hard-links src to dest, copying when linking is not possible.
+func copyFile(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// downloadImagesChunked downloads the pages listed in pending, saving page i
+// as names[i] plus the extension of its format, and reports each one that
+// completes, with its size and checksum, to done. Transient
+// failures are retried according to the configured retry policy. It returns one
+// result per pending page, in the order of pending. Pages not started by the
+// time ctx is done fail with ctx's error.
+func (d *Downloader) downloadImagesChunked(ctx context.Context, pages []PageState, pending []int, names []string, outputDir string, opts scraper.RequestOptions, done func(idx int, page PageState)) []domain.PageResult {
+	results := make([]domain.PageResult, len(pending))
 	var wg sync.WaitGroup
-	for i, url := range imageURLs {
+	for i, idx := range pending {
 		wg.Add(1)
-		go func(idx int, u string) {
+		go func(i, idx int, page PageState) {
 			defer wg.Done()
-			imageSemaphore <- struct{}{}
-			defer func() { <-imageSemaphore }()
-			DownloadImageInChunks(u, outputDir, idx+1)
-		}(i, url)
+			res := domain.PageResult{Index: idx + 1, URL: page.URL}
+			select {
+			case d.imageSemaphore <- struct{}{}:
+				defer func() { <-d.imageSemaphore }()
+			case <-ctx.Done():
+				res.Error = ctx.Err().Error()
+				results[i] = res
+				return
+			}
+
+			retries, err := d.retry.Do(ctx, func() error {
+				ctx, _ := startAttempt(ctx)
+				file, img, err := d.DownloadImageInChunks(ctx, page.URL, outputDir, names[idx], opts)
+				if err != nil {
+					abandonAttempt(ctx)
+					return err
+				}
+				page.File, page.Format, page.Width, page.Height = file, img.Format, img.Width, img.Height
+				page.SHA256, page.Size, err = hashFile(filepath.Join(outputDir, page.File))
+				if err != nil {
+					abandonAttempt(ctx)
+					return retry.Permanent(err)
+				}
+				return nil
+			})
+			res.Retries = retries
+			res.Format, res.Width, res.Height = page.Format, page.Width, page.Height
+			if err != nil {
+				var httpErr *retry.StatusError
+				if errors.As(err, &httpErr) {
+					res.StatusCode = httpErr.StatusCode
+				}
+				res.Error = err.Error()
+			} else {
+				page.Done = true
+				done(idx, page)
+			}
+			results[i] = res
+		}(i, idx, pages[idx])
 	}
 	wg.Wait()
-	return nil
+	return results
+}
+
+// pageResults returns one result per page in reading order, combining the
+// results of this run with the manifest state of pages reused from disk.
+func pageResults(pages []PageState, pending []int, attempted []domain.PageResult) []domain.PageResult {
+	results := make([]domain.PageResult, len(pages))
+	for i, page := range pages {
+		results[i] = domain.PageResult{Index: i + 1, URL: page.URL, Format: page.Format, Width: page.Width, Height: page.Height}
+	}
+	for i, idx := range pending {
+		results[idx] = attempted[i]
+	}
+	return results
 }
 
 // DownloadImageInChunks downloads a single image, splitting it into chunks if supported.
-func DownloadImageInChunks(url, outputDir string, index int) error {
+// opts carries the source-specific headers (e.g. Referer) and rate limit. The
+// image is saved in outputDir as name plus the extension of its detected
+// format; the file name and image details are returned. Responses that are
+// not images fail with ErrNotImage.
+//
+// Bodies are streamed to a temporary file rather than held in memory: chunks
+// are written at their offsets in a file pre-allocated to the image size.
+// Cancelling ctx stops the download at the next rate limit wait or body
+// write.
+func (d *Downloader) DownloadImageInChunks(ctx context.Context, url, outputDir, name string, opts scraper.RequestOptions) (string, ImageInfo, error) {
 	req := fasthttp.AcquireRequest()
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
@@ -94,144 +378,255 @@ func DownloadImageInChunks(url, outputDir string, index int) error {
 
 	req.SetRequestURI(url)
 	req.Header.SetMethod("HEAD")
-	req.Header.Set("User-Agent", config.DefaultUserAgent)
-	req.Header.Set("Referer", "https://mangakatana.com/")
+	d.setHeaders(req, opts.Headers)
 
-	if err := fasthttpClient.DoTimeout(req, resp, config.DefaultHeadTimeout); err != nil {
-		return downloadImageFast(url, outputDir, index)
+	if err := d.doRequest(ctx, req, resp, d.cfg.HeadTimeout, opts.Limit); err != nil {
+		if ctx.Err() != nil {
+			return "", ImageInfo{}, ctx.Err()
+		}
+		return d.downloadImageFast(ctx, url, outputDir, name, opts)
 	}
 
 	acceptRanges := string(resp.Header.Peek("Accept-Ranges"))
 	contentLength := string(resp.Header.Peek("Content-Length"))
+	contentType := string(resp.Header.ContentType())
 
 	if acceptRanges != "bytes" || contentLength == "" {
-		return downloadImageFast(url, outputDir, index)
+		return d.downloadImageFast(ctx, url, outputDir, name, opts)
 	}
 
 	fileSize, err := strconv.ParseInt(contentLength, 10, 64)
-	if err != nil || fileSize == 0 || fileSize < int64(config.MinChunkSize) {
-		return downloadImageFast(url, outputDir, index)
+	if err != nil || fileSize == 0 || fileSize < d.cfg.MinChunkSize {
+		return d.downloadImageFast(ctx, url, outputDir, name, opts)
 	}
 
-	numChunks := config.DefaultNumChunks
+	numChunks := d.cfg.NumChunks
 	chunkSize := fileSize / int64(numChunks)
-	var wg sync.WaitGroup
-	var chunkMux sync.Mutex
-	var chunks []domain.ChunkDownload
-	var downloadErr error
-
-	for i := 0; i < numChunks; i++ {
-		wg.Add(1)
-		go func(chunkIdx int) {
-			defer wg.Done()
-			start := int64(chunkIdx) * chunkSize
-			end := start + chunkSize - 1
-			if chunkIdx == numChunks-1 {
-				end = fileSize - 1
-			}
-			data, err := downloadChunk(url, start, end)
-			if err != nil {
-				chunkMux.Lock()
-				if downloadErr == nil {
-					downloadErr = err
+	file, info, err := savePage(outputDir, name, contentType, fileSize, func(f *os.File) error {
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			firstErr error
+		)
+		for i := 0; i < numChunks; i++ {
+			wg.Add(1)
+			go func(chunkIdx int) {
+				defer wg.Done()
+				start := int64(chunkIdx) * chunkSize
+				end := start + chunkSize - 1
+				if chunkIdx == numChunks-1 {
+					end = fileSize - 1
 				}
-				chunkMux.Unlock()
-				return
-			}
-			chunkMux.Lock()
-			chunks = append(chunks, domain.ChunkDownload{Data: data, Start: start, End: end})
-			chunkMux.Unlock()
-		}(i)
-	}
-	wg.Wait()
-
-	if downloadErr != nil {
-		return downloadImageFast(url, outputDir, index)
-	}
-
-	// Sort chunks
-	sortedChunks := make([]domain.ChunkDownload, len(chunks))
-	for _, chunk := range chunks {
-		idx := int(chunk.Start / chunkSize)
-		if idx >= len(sortedChunks) {
-			idx = len(sortedChunks) - 1
-		}
-		sortedChunks[idx] = chunk
-	}
-
-	filename := filepath.Join(outputDir, fmt.Sprintf("%03d.jpg", index))
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	for _, chunk := range sortedChunks {
-		if _, err := file.Write(chunk.Data); err != nil {
-			return err
+				if err := d.downloadChunk(ctx, url, start, end, opts, io.NewOffsetWriter(f, start)); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}(i)
 		}
+		wg.Wait()
+		return firstErr
+	})
+	if errors.Is(err, errChunk) && ctx.Err() == nil {
+		abandonAttempt(ctx) // the chunks received are fetched again
+		return d.downloadImageFast(ctx, url, outputDir, name, opts)
 	}
-	return nil
+	return file, info, err
 }
 
-func downloadChunk(url string, start, end int64) ([]byte, error) {
+// errChunk marks a failed range request; the image is then fetched in one
+// piece instead.
+var errChunk = errors.New("chunk download failed")
+
+// downloadChunk streams bytes start to end (inclusive) of url into w. The
+// server must answer with exactly that range.
+func (d *Downloader) downloadChunk(ctx context.Context, url string, start, end int64, opts scraper.RequestOptions, w io.Writer) error {
 	req := fasthttp.AcquireRequest()
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
 	defer fasthttp.ReleaseResponse(resp)
 	req.SetRequestURI(url)
 	req.Header.SetMethod("GET")
-	req.Header.Set("User-Agent", config.DefaultUserAgent)
+	d.setHeaders(req, opts.Headers)
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp.StreamBody = true
 
-	if err := fasthttpClient.DoTimeout(req, resp, config.DefaultChunkTimeout); err != nil {
-		return nil, err
+	if err := d.doRequest(ctx, req, resp, d.cfg.ChunkTimeout, opts.Limit); err != nil {
+		return fmt.Errorf("%w: %w", errChunk, err)
+	}
+	if resp.StatusCode() != fasthttp.StatusPartialContent {
+		return fmt.Errorf("%w: %w", errChunk, statusError(resp))
 	}
-	if resp.StatusCode() != fasthttp.StatusPartialContent && resp.StatusCode() != fasthttp.StatusOK {
-		return nil, fmt.Errorf("HTTP %d", resp.StatusCode())
+	cw := &countingWriter{w: contextWriter{ctx, w}}
+	if err := resp.BodyWriteTo(cw); err != nil {
+		return fmt.Errorf("%w: %w", errChunk, err)
 	}
-	data := make([]byte, len(resp.Body()))
-	copy(data, resp.Body())
-	return data, nil
+	if want := end - start + 1; cw.n != want {
+		return fmt.Errorf("%w: got %d bytes for a %d byte range", errChunk, cw.n, want)
+	}
+	return nil
+}
+
+// contextWriter fails writes once ctx is done, which aborts a streamed
+// response body. Written bytes count towards the page attempt or chapter
+// progress tracked in ctx, if any.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	addBytes(c.ctx, n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func downloadImageFast(url, outputDir string, index int) error {
+// downloadImageFast streams the image in a single request.
+func (d *Downloader) downloadImageFast(ctx context.Context, url, outputDir, name string, opts scraper.RequestOptions) (string, ImageInfo, error) {
 	req := fasthttp.AcquireRequest()
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
 	defer fasthttp.ReleaseResponse(resp)
 	req.SetRequestURI(url)
 	req.Header.SetMethod("GET")
-	if err := fasthttpClient.DoTimeout(req, resp, config.DefaultChunkTimeout); err != nil {
+	d.setHeaders(req, opts.Headers)
+	resp.StreamBody = true
+	if err := d.doRequest(ctx, req, resp, d.cfg.ChunkTimeout, opts.Limit); err != nil {
+		return "", ImageInfo{}, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return "", ImageInfo{}, statusError(resp)
+	}
+	return savePage(outputDir, name, string(resp.Header.ContentType()), 0, func(f *os.File) error {
+		return resp.BodyWriteTo(contextWriter{ctx, f})
+	})
+}
+
+// statusError builds a retry.StatusError from an unexpected response.
+func statusError(resp *fasthttp.Response) error {
+	return &retry.StatusError{
+		StatusCode: resp.StatusCode(),
+		RetryAfter: retry.ParseRetryAfter(string(resp.Header.Peek("Retry-After")), time.Now()),
+	}
+}
+
+// doRequest sends req once the host's rate limit allows it, and slows the
+// host down if it answers 429 Too Many Requests. The request gives up after
+// timeout or at ctx's deadline, whichever is sooner; fasthttp cannot abort a
+// request in flight, so a cancelled ctx is only noticed before sending.
+func (d *Downloader) doRequest(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration, lim ratelimit.Limit) error {
+	host := string(req.URI().Host())
+	if err := d.scraper.Limiter().Wait(ctx, host, lim); err != nil {
 		return err
 	}
-	filename := filepath.Join(outputDir, fmt.Sprintf("%03d.jpg", index))
-	return os.WriteFile(filename, resp.Body(), 0644)
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := d.client.DoDeadline(req, resp, deadline); err != nil {
+		return err
+	}
+	if resp.StatusCode() == fasthttp.StatusTooManyRequests {
+		d.scraper.Limiter().Penalize(host)
+	}
+	return nil
+}
+
+// setHeaders applies the default User-Agent and any source-specific headers.
+func (d *Downloader) setHeaders(req *fasthttp.Request, headers map[string]string) {
+	req.Header.Set("User-Agent", d.cfg.UserAgent)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+}
+
+// writeArchive packages the pages in dir as the configured output format.
+func (d *Downloader) writeArchive(dir, dest string, manga *domain.MangaDetails, chapter domain.Chapter, pages []PageState) error {
+	switch d.cfg.OutputFormat {
+	case "epub":
+		return createEPUB(dest, newChapterBook(manga, chapter, dir, pages))
+	case "pdf":
+		return createPDF(dest, newChapterBook(manga, chapter, dir, pages))
+	default:
+		entries := cbzEntries([]bookSection{{Dir: dir, Pages: pages}}, false)
+		return createCBZ(dest, NewComicInfo(manga, chapter, pages), entries)
+	}
+}
+
+// cbzEntry is a file stored in a CBZ archive.
+type cbzEntry struct {
+	Name string // slash-separated path inside the archive
+	Path string // file on disk
+}
+
+// cbzEntries lists the pages of sections in reading order, each in a
+// folder named after its section directory when folders is set.
+func cbzEntries(sections []bookSection, folders bool) []cbzEntry {
+	var entries []cbzEntry
+	for _, section := range sections {
+		for _, p := range section.Pages {
+			name := p.File
+			if folders {
+				name = filepath.Base(section.Dir) + "/" + p.File
+			}
+			entries = append(entries, cbzEntry{Name: name, Path: filepath.Join(section.Dir, p.File)})
+		}
+	}
+	return entries
 }
 
-func createCBZ(src, dest string) error {
-	f, err := os.Create(dest)
+// createCBZ writes ComicInfo.xml, if info is not nil, and exactly the
+// files listed, in order, into dest. The archive is read back and checked
+// against that list before it replaces dest.
+func createCBZ(dest string, info *ComicInfo, files []cbzEntry) (err error) {
+	var names []string
+	if info != nil {
+		names = append(names, ComicInfoName)
+	}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	f, err := atomicfile.Create(dest)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer f.Finish(&err, func(f *os.File) error { return verifyZip(f, names) })
 	w := zip.NewWriter(f)
-	defer w.Close()
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		header, _ := zip.FileInfoHeader(info)
-		header.Method = zip.Deflate
-		header.Name, _ = filepath.Rel(src, path)
-		writer, _ := w.CreateHeader(header)
-		file, _ := os.Open(path)
-		defer file.Close()
-		io.Copy(writer, file)
-		return nil
-	})
-}
 
-func SanitizeFilename(name string) string {
-	return regexp.MustCompile(`[<>:"/\\|?*]`).ReplaceAllString(name, "_")
+	if info != nil {
+		data, err := info.Marshal()
+		if err != nil {
+			return err
+		}
+		writer, err := w.Create(ComicInfoName)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := copyToZip(w, file.Name, file.Path); err != nil {
+			return err
+		}
+	}
+	return w.Close()
 }
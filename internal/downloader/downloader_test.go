@@ -1,7 +1,34 @@
 package downloader
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"mangadl/internal/config"
+	"mangadl/internal/domain"
+	"mangadl/internal/scraper"
+
+	"github.com/PuerkitoBio/goquery"
 )
 
 func TestSanitizeFilename(t *testing.T) {
@@ -14,6 +41,20 @@ func TestSanitizeFilename(t *testing.T) {
 		{"Name/With/Slashes", "Name_With_Slashes"},
 		{"<Invalid>Chars?", "_Invalid_Chars_"},
 		{"mixed|separators\\here", "mixed_separators_here"},
+		{"Tab\tand\x00null", "Tab_and_null"},
+		{"Trailing dots...", "Trailing dots"},
+		{"  padded  ", "padded"},
+		{"..", "_"},
+		{".", "_"},
+		{"", "_"},
+		{"CON", "CON_"},
+		{"nul.txt", "nul_.txt"},
+		{"com1", "com1_"},
+		{"Console", "Console"},
+		{"Cafe\u0301", "Caf\u00e9"}, // NFC
+		{strings.Repeat("a", 300), strings.Repeat("a", maxNameBytes)},
+		{strings.Repeat("\u00e9", 150), strings.Repeat("\u00e9", maxNameBytes/2)},
+		{"bad \xff byte", "bad _ byte"},
 	}
 
 	for _, tt := range tests {
@@ -23,3 +64,1279 @@ func TestSanitizeFilename(t *testing.T) {
 		}
 	}
 }
+
+func TestClaimDir(t *testing.T) {
+	m, err := LoadManifest(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1 := domain.Chapter{Name: "Chapter 1?", URL: "u1", ID: "c1"}
+	c2 := domain.Chapter{Name: "Chapter 1*", URL: "u2", ID: "c2"}
+	c3 := domain.Chapter{Name: "Chapter 1|", URL: "u3", ID: "c3"}
+	if got := m.ClaimDir(c1, "Chapter 1_"); got != "Chapter 1_" {
+		t.Errorf("first claim = %q", got)
+	}
+	// Another chapter sanitizing to the same name, in any case, gets a suffix.
+	if got := m.ClaimDir(c2, "chapter 1_"); got != "chapter 1_ (2)" {
+		t.Errorf("colliding claim = %q", got)
+	}
+	if got := m.ClaimDir(c3, "Chapter 1_"); got != "Chapter 1_ (3)" {
+		t.Errorf("third claim = %q", got)
+	}
+	// A chapter keeps its directory, even after its pages are reset.
+	m.SetPages(c2, nil)
+	if got := m.ClaimDir(c2, "other"); got != "chapter 1_ (2)" {
+		t.Errorf("repeated claim = %q", got)
+	}
+}
+
+func TestJoinWithin(t *testing.T) {
+	root := filepath.Join("out", "series")
+	for _, rel := range []string{"a", "a/b", "a/../b"} {
+		if _, err := joinWithin(root, rel); err != nil {
+			t.Errorf("joinWithin(%q): %v", rel, err)
+		}
+	}
+	for _, rel := range []string{"..", "../x", "a/../../x", "/abs", ""} {
+		if got, err := joinWithin(root, rel); err == nil {
+			t.Errorf("joinWithin(%q) = %q; want an error", rel, got)
+		}
+	}
+}
+
+func TestManifestResume(t *testing.T) {
+	dir := t.TempDir()
+	chapter := domain.Chapter{Name: "Chapter 1", URL: "https://example.com/manga/x/c1", ID: "c1"}
+
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	m.SetPages(chapter, []PageState{
+		{URL: "https://example.com/1.jpg", File: "001.jpg"},
+		{URL: "https://example.com/2.jpg", File: "002.jpg"},
+	})
+
+	data := []byte("page one")
+	if err := os.WriteFile(filepath.Join(dir, "001.jpg"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, size, err := hashFile(filepath.Join(dir, "001.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := PageState{URL: "https://example.com/1.jpg", File: "001.jpg", Size: size, SHA256: sum, Done: true}
+	if err := m.MarkPage(chapter, 0, page); err != nil {
+		t.Fatalf("MarkPage: %v", err)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// Reload from disk, bypassing the in-process cache.
+	manifestsMu.Lock()
+	delete(manifests, m.path)
+	manifestsMu.Unlock()
+	m, err = LoadManifest(dir)
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+
+	// The chapter is found by its ID, even under a new URL.
+	moved := chapter
+	moved.URL = "https://mirror.example.com/manga/x/c1"
+	st, ok := m.Chapter(moved)
+	if !ok || len(st.Pages) != 2 {
+		t.Fatalf("chapter not restored: %+v", st)
+	}
+	if !verifyPage(dir, st.Pages[0]) {
+		t.Errorf("completed page should verify")
+	}
+	if verifyPage(dir, st.Pages[1]) {
+		t.Errorf("pending page should not verify")
+	}
+
+	// A truncated page must be downloaded again.
+	if err := os.WriteFile(filepath.Join(dir, "001.jpg"), data[:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if verifyPage(dir, st.Pages[0]) {
+		t.Errorf("truncated page should not verify")
+	}
+
+	if m.IsComplete(chapter) {
+		t.Errorf("chapter without archive should not be complete")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Chapter 1.cbz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MarkComplete(chapter, "Chapter 1.cbz"); err != nil {
+		t.Fatalf("MarkComplete: %v", err)
+	}
+	if !m.IsComplete(chapter) {
+		t.Errorf("archived chapter should be complete")
+	}
+}
+
+func TestManifestAdoptsURLKeys(t *testing.T) {
+	dir := t.TempDir()
+	chapter := domain.Chapter{Name: "Chapter 1", URL: "https://example.com/manga/x/c1", ID: "c1"}
+	legacy := `{"chapters": {"https://example.com/manga/x/c1": {"name": "Chapter 1",
+		"url": "https://example.com/manga/x/c1", "dir": "Chapter 1", "archive": "Chapter 1.cbz", "complete": true}}}`
+	if err := os.WriteFile(filepath.Join(dir, ManifestName), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Chapter 1.cbz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsComplete(chapter) {
+		t.Fatal("chapter recorded under its URL should be found by ID")
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Chapters["c1"]; !ok || len(m.Chapters) != 1 {
+		t.Errorf("entry not moved to the chapter ID: %v", m.Chapters)
+	}
+}
+
+func TestComicInfo(t *testing.T) {
+	manga := &domain.MangaDetails{
+		Title:    "One Piece",
+		Summary:  "Pirates & treasure",
+		Authors:  []string{"Oda Eiichiro"},
+		Genres:   []string{"Action", "Adventure"},
+		Language: "en",
+	}
+	chapter := domain.Chapter{Name: "Chapter 10.5: Romance Dawn", URL: "https://example.com/c10.5"}
+	pages := []PageState{{File: "001.jpg", Size: 100}, {File: "002.jpg", Size: 200}}
+
+	info := NewComicInfo(manga, chapter, pages)
+	if info.Number != "10.5" || info.Title != "Romance Dawn" || info.PageCount != 2 {
+		t.Errorf("unexpected ComicInfo: %+v", info)
+	}
+
+	data, err := info.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	xmlStr := string(data)
+	for _, want := range []string{
+		"<Series>One Piece</Series>",
+		"<Summary>Pirates &amp; treasure</Summary>",
+		"<Genre>Action, Adventure</Genre>",
+		"<Web>https://example.com/c10.5</Web>",
+		`<Page Image="0" Type="FrontCover" ImageSize="100"></Page>`,
+		`<Page Image="1" ImageSize="200"></Page>`,
+	} {
+		if !strings.Contains(xmlStr, want) {
+			t.Errorf("ComicInfo.xml missing %q:\n%s", want, xmlStr)
+		}
+	}
+}
+
+func TestChapterTitle(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Chapter 12: The Bet", "The Bet"},
+		{"Vol.3 Chapter 12 - The Bet", "The Bet"},
+		{"Chapter 12", "Chapter 12"},
+		{"Extra", "Extra"},
+	}
+	for _, tt := range tests {
+		if got := chapterTitle(tt.input); got != tt.expected {
+			t.Errorf("chapterTitle(%q) = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// testSource serves chapters from an httptest server.
+type testSource struct{ host string }
+
+func (s testSource) Name() string                                { return "Test" }
+func (s testSource) Match(u *url.URL) bool                       { return u.Host == s.host }
+func (s testSource) Headers() map[string]string                  { return nil }
+func (s testSource) Chapters(*goquery.Document) []domain.Chapter { return nil }
+func (s testSource) Series(*goquery.Document) (*domain.MangaDetails, error) {
+	return &domain.MangaDetails{}, nil
+}
+func (s testSource) Pages(doc *goquery.Document) []string {
+	var urls []string
+	doc.Find("img").Each(func(i int, sel *goquery.Selection) {
+		src, _ := sel.Attr("src")
+		urls = append(urls, doc.Url.ResolveReference(&url.URL{Path: src}).String())
+	})
+	return urls
+}
+
+// newTestDownloader returns a downloader with short retry delays.
+func newTestDownloader() *Downloader {
+	cfg := config.Default()
+	cfg.Retry = config.RetryConfig{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	return New(cfg, scraper.New(cfg))
+}
+
+func TestDownloadChapterReportsFailedPages(t *testing.T) {
+	var brokenPage atomic.Bool
+	brokenPage.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="/img/1.jpg"><img src="/img/2.jpg"><img src="/img/3.jpg"></body></html>`)
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/img/2.jpg" && brokenPage.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testPNG)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	d := newTestDownloader()
+	mangaDir := t.TempDir()
+	manga := &domain.MangaDetails{Title: "Test"}
+	chapter := domain.Chapter{Name: "Chapter 1", URL: srv.URL + "/chapter"}
+
+	result, err := d.DownloadChapter(context.Background(), manga, chapter, mangaDir)
+	if !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	if result.Total != 3 || result.Succeeded != 2 || len(result.Failed) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if f := result.Failed[0]; f.Index != 2 || f.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected failed page: %+v", f)
+	}
+	if _, err := os.Stat(filepath.Join(mangaDir, "Chapter 1.cbz")); !os.IsNotExist(err) {
+		t.Errorf("no archive should be written for an incomplete chapter")
+	}
+
+	// Once the page is available, only it is fetched and the archive is built.
+	brokenPage.Store(false)
+	result, err = d.DownloadChapter(context.Background(), manga, chapter, mangaDir)
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if result.Reused != 2 || result.Attempted != 1 || result.Succeeded != 1 {
+		t.Errorf("unexpected resumed result: %+v", result)
+	}
+
+	zr, err := zip.OpenReader(result.Archive)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	want := []string{ComicInfoName, "001.png", "002.png", "003.png"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("archive contains %v; want %v", names, want)
+	}
+
+	result, err = d.DownloadChapter(context.Background(), manga, chapter, mangaDir)
+	if err != nil || !result.Skipped {
+		t.Errorf("completed chapter should be skipped: %+v, %v", result, err)
+	}
+}
+
+func TestDownloadChapterRefreshesPageURLs(t *testing.T) {
+	// Image URLs carry a token that the chapter page renews; page 2 fails
+	// until the token changes, and old tokens stop working.
+	var token atomic.Int32
+	token.Store(1)
+	var mu sync.Mutex
+	fetched := make(map[string]bool)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body><img src="/img/%d/1.jpg"><img src="/img/%[1]d/2.jpg"><img src="/img/%[1]d/3.jpg"></body></html>`, token.Load())
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		current := fmt.Sprintf("/img/%d/", token.Load())
+		if !strings.HasPrefix(r.URL.Path, current) || r.URL.Path == "/img/1/2.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		fetched[r.URL.Path] = true
+		mu.Unlock()
+		w.Write(testPNG)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	d := newTestDownloader()
+	mangaDir := t.TempDir()
+	manga := &domain.MangaDetails{Title: "Test"}
+	chapter := domain.Chapter{Name: "Chapter 1", URL: srv.URL + "/chapter", ID: "c1"}
+
+	if _, err := d.DownloadChapter(context.Background(), manga, chapter, mangaDir); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+
+	// The resumed download fetches the new URLs and keeps the saved pages.
+	token.Store(2)
+	clear(fetched)
+	result, err := d.DownloadChapter(context.Background(), manga, chapter, mangaDir)
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if result.Reused != 2 || result.Succeeded != 1 || len(fetched) != 1 || !fetched["/img/2/2.jpg"] {
+		t.Errorf("resumed result: %+v fetching %v; want only page 2 fetched", result, fetched)
+	}
+	m, err := LoadManifest(mangaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := m.Chapter(chapter)
+	for i, page := range state.Pages {
+		if want := fmt.Sprintf("%s/img/2/%d.jpg", srv.URL, i+1); page.URL != want || !page.Done {
+			t.Errorf("page %d = %+v; want %s, done", i+1, page, want)
+		}
+	}
+}
+
+func TestDownloadChapterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cancelled atomic.Bool
+	cancelled.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="/img/1.jpg"><img src="/img/2.jpg"><img src="/img/3.jpg"></body></html>`)
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/img/2.jpg" && cancelled.Load() {
+			cancel()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(testPNG)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	d := newTestDownloader()
+	mangaDir := t.TempDir()
+	manga := &domain.MangaDetails{Title: "Test"}
+	chapter := domain.Chapter{Name: "Chapter 1", URL: srv.URL + "/chapter", ID: "c1"}
+
+	result, err := d.DownloadChapter(ctx, manga, chapter, mangaDir)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if result == nil || result.Succeeded >= 3 || result.Retries != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(mangaDir, "Chapter 1.cbz")); !os.IsNotExist(err) {
+		t.Errorf("no archive should be written for a cancelled chapter")
+	}
+
+	// The pages saved before the cancel are recorded and reused.
+	cancelled.Store(false)
+	saved := result.Succeeded
+	result, err = d.DownloadChapter(context.Background(), manga, chapter, mangaDir)
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if result.Reused != saved || result.Succeeded != 3-saved {
+		t.Errorf("resumed result: %+v; want %d pages reused", result, saved)
+	}
+}
+
+func TestDownloadChapterProgress(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="/img/1.jpg"><img src="/img/2.jpg"><img src="/img/3.jpg"></body></html>`)
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	var (
+		mu      sync.Mutex
+		reports []domain.ChapterProgress
+	)
+	ctx := WithProgress(context.Background(), func(p domain.ChapterProgress) {
+		mu.Lock()
+		reports = append(reports, p)
+		mu.Unlock()
+	})
+
+	d := newTestDownloader()
+	chapter := domain.Chapter{Name: "Chapter 1", URL: srv.URL + "/chapter", ID: "c1"}
+	result, err := d.DownloadChapter(ctx, &domain.MangaDetails{Title: "Test"}, chapter, t.TempDir())
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if result.Bytes != 3*int64(len(testPNG)) {
+		t.Errorf("result.Bytes = %d; want %d", result.Bytes, 3*len(testPNG))
+	}
+
+	if len(reports) < 4 {
+		t.Fatalf("got %d reports; want the start and one per page", len(reports))
+	}
+	if first := reports[0]; first.PagesTotal != 3 || first.PagesDone != 0 {
+		t.Errorf("first report = %+v", first)
+	}
+	last := reports[len(reports)-1]
+	if last.PagesDone != 3 || last.Bytes != 3*int64(len(testPNG)) {
+		t.Errorf("last report = %+v; want 3 pages and %d bytes", last, 3*len(testPNG))
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].PagesDone < reports[i-1].PagesDone || reports[i].Bytes < reports[i-1].Bytes {
+			t.Errorf("report %d went backwards: %+v after %+v", i, reports[i], reports[i-1])
+		}
+	}
+}
+
+func TestDownloadChapterBytesLeaveOutFailedAttempts(t *testing.T) {
+	// The first response for page 2 is an error page served with status
+	// 200; its bytes are received but must not count.
+	var served atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="/img/1.jpg"><img src="/img/2.jpg"><img src="/img/3.jpg"></body></html>`)
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/img/2.jpg" && r.Method == http.MethodGet && !served.Swap(true) {
+			fmt.Fprint(w, "<html><body>temporarily unavailable</body></html>")
+			return
+		}
+		w.Write(testPNG)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	var last atomic.Int64
+	ctx := WithProgress(context.Background(), func(p domain.ChapterProgress) { last.Store(p.Bytes) })
+	chapter := domain.Chapter{Name: "Chapter 1", URL: srv.URL + "/chapter", ID: "c1"}
+	result, err := newTestDownloader().DownloadChapter(ctx, &domain.MangaDetails{Title: "Test"}, chapter, t.TempDir())
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	want := 3 * int64(len(testPNG))
+	if result.Retries != 1 || result.Bytes != want || last.Load() != want {
+		t.Errorf("retries %d, result.Bytes %d, last report %d; want 1 retry and %d bytes", result.Retries, result.Bytes, last.Load(), want)
+	}
+}
+
+func TestDownloadChapterRetriesTransientErrors(t *testing.T) {
+	var hits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="/img/1.jpg"></body></html>`)
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && hits.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(testPNG)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	chapter := domain.Chapter{Name: "Chapter 1", URL: srv.URL + "/chapter"}
+	result, err := newTestDownloader().DownloadChapter(context.Background(), &domain.MangaDetails{Title: "Test"}, chapter, t.TempDir())
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if result.Retries != 2 || result.Succeeded != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if p := result.Pages[0]; p.Format != "png" || p.Width != 3 || p.Height != 2 {
+		t.Errorf("unexpected page details: %+v", p)
+	}
+}
+
+func TestDownloadChapterTemplates(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="/img/1.jpg"><img src="/img/2.jpg"></body></html>`)
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	cfg := config.Default()
+	cfg.OutputDir = t.TempDir()
+	cfg.Templates = config.TemplateConfig{
+		Series:  "{source}/{series}",
+		Chapter: "Vol {volume}/{chapter_number:04} {chapter_title}",
+		Page:    "{chapter_number:03}-{page:02}",
+		Volume:  "{volume_title}",
+	}
+	manga := &domain.MangaDetails{Title: "A/B", Source: "Test"}
+	mangaDir, err := SeriesDir(cfg, manga)
+	if err != nil {
+		t.Fatalf("SeriesDir: %v", err)
+	}
+	if want := filepath.Join(cfg.OutputDir, "Test", "A_B"); mangaDir != want {
+		t.Errorf("SeriesDir = %s; want %s", mangaDir, want)
+	}
+
+	chapter := domain.Chapter{Name: "Chapter 7.5: Side Story", URL: srv.URL + "/chapter", Volume: "2"}
+	result, err := New(cfg, scraper.New(cfg)).DownloadChapter(context.Background(), manga, chapter, mangaDir)
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if want := filepath.Join(mangaDir, "Vol 2", "0007.5 Side Story.cbz"); result.Archive != want {
+		t.Errorf("archive = %s; want %s", result.Archive, want)
+	}
+	for _, name := range []string{"007.5-01.png", "007.5-02.png"} {
+		if _, err := os.Stat(filepath.Join(mangaDir, "Vol 2", "0007.5 Side Story", name)); err != nil {
+			t.Errorf("page not saved under its template name: %v", err)
+		}
+	}
+}
+
+// testPNG is a 3x2 PNG image.
+var testPNG = func() []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}()
+
+func TestDetectImage(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		format      string
+		width       int
+		wantErr     bool
+	}{
+		{"png", testPNG, "image/jpeg", "png", 3, false},
+		{"jpeg magic", []byte{0xFF, 0xD8, 0xFF, 0xE0}, "", "jpeg", 0, false},
+		{"webp magic", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "", "webp", 0, false},
+		{"content type fallback", []byte("\x00\x00\x00\x1cftypheic"), "image/avif", "avif", 0, false},
+		{"html error page", []byte("<!DOCTYPE html><html><body>Not found</body></html>"), "image/jpeg", "", 0, true},
+		{"html content type", []byte("oops"), "text/html; charset=utf-8", "", 0, true},
+		{"empty body", nil, "image/png", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := detectImage(bytes.NewReader(tt.data), tt.contentType)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNotImage) {
+					t.Fatalf("expected ErrNotImage, got %+v, %v", info, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectImage: %v", err)
+			}
+			if info.Format != tt.format || info.Width != tt.width {
+				t.Errorf("got %+v; want format %s, width %d", info, tt.format, tt.width)
+			}
+		})
+	}
+}
+
+func TestSavePageReplacesStaleExtension(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "001.jpg"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name, info, err := savePage(dir, "001", "", 0, func(f *os.File) error {
+		_, err := f.Write(testPNG)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("savePage: %v", err)
+	}
+	if name != "001.png" || info.Ext() != ".png" {
+		t.Errorf("got %s (%+v); want 001.png", name, info)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "001.jpg")); !os.IsNotExist(err) {
+		t.Errorf("stale 001.jpg should be removed")
+	}
+
+	// A failed write leaves neither a page nor a temporary file behind.
+	_, _, err = savePage(dir, "002", "", 0, func(f *os.File) error {
+		f.Write(testPNG[:4])
+		return io.ErrUnexpectedEOF
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries after a failed write; want only 001.png", len(entries))
+	}
+}
+
+func TestCreateCBZKeepsPreviousArchiveOnFailure(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "001.png"), testPNG, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "Chapter 1.cbz")
+	files := []cbzEntry{{Name: "001.png", Path: filepath.Join(src, "001.png")}}
+	if err := createCBZ(dest, nil, files); err != nil {
+		t.Fatalf("createCBZ: %v", err)
+	}
+	before, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A page that cannot be read fails the rewrite, which must leave the
+	// existing archive intact and no temporary file behind.
+	if err := os.Symlink(filepath.Join(src, "missing.png"), filepath.Join(src, "002.png")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	files = append(files, cbzEntry{Name: "002.png", Path: filepath.Join(src, "002.png")})
+	if err := createCBZ(dest, nil, files); err == nil {
+		t.Fatal("expected an error")
+	}
+	after, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(before, after) {
+		t.Errorf("archive changed by a failed write (err %v)", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dest)); len(entries) != 1 {
+		t.Errorf("temporary archive left behind: %v", entries)
+	}
+}
+
+func TestCreateCBZArchivesOnlyGivenPages(t *testing.T) {
+	src := t.TempDir()
+	// 2.png and 10.png sort the other way round by name, and the stray
+	// split page left by other convert settings must not be archived.
+	for _, name := range []string{"2.png", "10.png", "003-1.png"} {
+		if err := os.WriteFile(filepath.Join(src, name), testPNG, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages := []PageState{{File: "2.png", Done: true}, {File: "10.png", Done: true}}
+	dest := filepath.Join(t.TempDir(), "Chapter 1.cbz")
+	err := createCBZ(dest, NewComicInfo(&domain.MangaDetails{Title: "A"}, domain.Chapter{Name: "Chapter 1"}, pages),
+		cbzEntries([]bookSection{{Dir: src, Pages: pages}}, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{ComicInfoName, "2.png", "10.png"}; !slices.Equal(names, want) {
+		t.Errorf("entries = %v; want %v", names, want)
+	}
+}
+
+func TestVerifyZip(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"001.png", "002.png"} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(testPNG)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	open := func(data []byte) *os.File {
+		path := filepath.Join(t.TempDir(), "a.cbz")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	want := []string{"001.png", "002.png"}
+	if err := verifyZip(open(data), want); err != nil {
+		t.Errorf("valid archive: %v", err)
+	}
+	if err := verifyZip(open(data), append(want, "003.png")); err == nil {
+		t.Error("missing entry should fail")
+	}
+	if err := verifyZip(open(data), []string{"002.png", "001.png"}); err == nil {
+		t.Error("entries out of order should fail")
+	}
+	if err := verifyZip(open(data[:len(data)/2]), want); err == nil {
+		t.Error("truncated archive should fail")
+	}
+	corrupt := bytes.Clone(data)
+	i := bytes.Index(corrupt, testPNG[8:16])
+	corrupt[i] ^= 0xFF
+	if err := verifyZip(open(corrupt), want); err == nil {
+		t.Error("corrupt entry should fail")
+	}
+}
+
+func TestLoadManifestCleansStaleFiles(t *testing.T) {
+	mangaDir := t.TempDir()
+	files := map[string]bool{
+		"Chapter 1/001.png":                 true,
+		"Chapter 1/002.part":                false,
+		"Chapter 1.cbz.part":                false,
+		ManifestName + partSuffix:           false,
+		stageDirName + "/Chapter 1/001.png": false,
+		"Chapter 2 (notes.part)/001.png":    true,
+	}
+	for name := range files {
+		path := filepath.Join(mangaDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, testPNG, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := LoadManifest(mangaDir); err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	for name, keep := range files {
+		_, err := os.Stat(filepath.Join(mangaDir, filepath.FromSlash(name)))
+		if keep && err != nil {
+			t.Errorf("%s was removed", name)
+		}
+		if !keep && !os.IsNotExist(err) {
+			t.Errorf("%s was kept", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(mangaDir, stageDirName)); !os.IsNotExist(err) {
+		t.Errorf("staging directory was kept")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+
+	// A 400x200 white spread with content in the middle of each half.
+	spread := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(spread, spread.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(spread, image.Rect(20, 20, 380, 180), image.Black, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, spread); err != nil {
+		t.Fatal(err)
+	}
+	var portrait bytes.Buffer
+	if err := png.Encode(&portrait, image.NewGray(image.Rect(0, 0, 20, 30))); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"001.png": buf.Bytes(), "002.png": portrait.Bytes()} {
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages := []PageState{
+		{File: "001.png", Format: "png", Done: true},
+		{File: "002.png", Format: "png", Done: true},
+	}
+
+	cfg := config.Default()
+	cfg.Devices = map[string]config.DeviceProfile{"tiny": {Width: 100, Height: 150, Grayscale: true}}
+	cfg.Convert = config.ConvertConfig{Format: "jpeg", Quality: 80, Device: "tiny", CropMargins: true, Spreads: config.SpreadsSplit}
+	p, err := newPipeline(cfg)
+	if err != nil {
+		t.Fatalf("newPipeline: %v", err)
+	}
+
+	out, err := p.processPages(src, dest, pages)
+	if err != nil {
+		t.Fatalf("processPages: %v", err)
+	}
+	var names []string
+	for _, page := range out {
+		names = append(names, page.File)
+		if page.Width > 100 || page.Height > 150 || !verifyPage(dest, page) {
+			t.Errorf("page %s does not fit the device or verify: %+v", page.File, page)
+		}
+	}
+	if want := "001-1.jpg,001-2.jpg,002.jpg"; strings.Join(names, ",") != want {
+		t.Errorf("archived pages %v; want %s", names, want)
+	}
+	// The cropped 360x160 spread splits into two 180x160 halves, scaled to fit.
+	if out[0].Width != 100 || out[0].Height != 88 {
+		t.Errorf("split page is %dx%d; want 100x88", out[0].Width, out[0].Height)
+	}
+	if _, err := os.Stat(filepath.Join(src, "001.png")); err != nil {
+		t.Errorf("downloaded page must be left in place: %v", err)
+	}
+}
+
+func TestPipelineKeepsUnchangedPages(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "001.png"), testPNG, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Convert.Spreads = config.SpreadsRotate
+	p, _ := newPipeline(cfg)
+
+	// The 3x2 test page is landscape, so it is rotated.
+	out, err := p.processPages(src, dest, []PageState{{File: "001.png", Format: "png", Done: true}})
+	if err != nil {
+		t.Fatalf("processPages: %v", err)
+	}
+	if out[0].Width != 2 || out[0].Height != 3 {
+		t.Errorf("rotated page is %dx%d; want 2x3", out[0].Width, out[0].Height)
+	}
+
+	// A portrait page needs nothing and is copied byte for byte.
+	portrait := image.NewGray(image.Rect(0, 0, 2, 3))
+	var buf bytes.Buffer
+	png.Encode(&buf, portrait)
+	if err := os.WriteFile(filepath.Join(src, "002.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = p.processPages(src, dest, []PageState{{File: "002.png", Format: "png", Done: true}})
+	if err != nil {
+		t.Fatalf("processPages: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, out[0].File)); !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("unchanged page was re-encoded")
+	}
+}
+
+func TestCreateEPUB(t *testing.T) {
+	dir := t.TempDir()
+	var pages []PageState
+	for i := 1; i <= 2; i++ {
+		name := fmt.Sprintf("%03d.png", i)
+		if err := os.WriteFile(filepath.Join(dir, name), testPNG, 0644); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, PageState{File: name, Format: "png"})
+	}
+	manga := &domain.MangaDetails{Title: "Tom & Jerry", Authors: []string{"Oda"}, Language: "ja"}
+	chapter := domain.Chapter{Name: "Chapter 1", URL: "https://example.com/c1"}
+	dest := filepath.Join(t.TempDir(), "Chapter 1.epub")
+	if err := createEPUB(dest, newChapterBook(manga, chapter, dir, pages)); err != nil {
+		t.Fatalf("createEPUB: %v", err)
+	}
+
+	zr, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatalf("open epub: %v", err)
+	}
+	defer zr.Close()
+	if f := zr.File[0]; f.Name != "mimetype" || f.Method != zip.Store {
+		t.Errorf("first entry must be an uncompressed mimetype, got %s (method %d)", f.Name, f.Method)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{
+		`<dc:title>Tom &amp; Jerry - Chapter 1</dc:title>`,
+		`<dc:language>ja</dc:language>`,
+		`<dc:creator>Oda</dc:creator>`,
+		`pre-paginated`,
+		`page-progression-direction="rtl"`,
+		`href="images/0001.png" media-type="image/png" properties="cover-image"`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf is missing %q", want)
+		}
+	}
+	if !strings.Contains(files["OEBPS/nav.xhtml"], `<a href="pages/0001.xhtml">Chapter 1</a>`) {
+		t.Errorf("nav does not link the chapter: %s", files["OEBPS/nav.xhtml"])
+	}
+	if !strings.Contains(files["OEBPS/pages/0002.xhtml"], `content="width=3, height=2"`) {
+		t.Errorf("page viewport not sized to the image: %s", files["OEBPS/pages/0002.xhtml"])
+	}
+	if files["OEBPS/images/0002.png"] != string(testPNG) {
+		t.Errorf("page image not stored verbatim")
+	}
+}
+
+func TestCreatePDF(t *testing.T) {
+	dir := t.TempDir()
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 4, 6)), nil); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{"001.jpg": jpg.Bytes(), "002.png": testPNG}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &book{
+		Title:   "Tom (& Jerry)",
+		Authors: []string{"尾田栄一郎"},
+		Sections: []bookSection{
+			{Title: "Chapter 1", Dir: dir, Pages: []PageState{{File: "001.jpg"}}},
+			{Title: "Chapter 2", Dir: dir, Pages: []PageState{{File: "002.png"}}},
+		},
+	}
+	dest := filepath.Join(t.TempDir(), "out.pdf")
+	if err := createPDF(dest, b); err != nil {
+		t.Fatalf("createPDF: %v", err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf := string(data)
+
+	for _, want := range []string{
+		"%PDF-1.7",
+		`/Title (Tom \(& Jerry\))`,
+		"/Author <FEFF5C3E753068044E0090CE>",
+		"/Direction /R2L",
+		"/MediaBox [0 0 4 6]",
+		"/MediaBox [0 0 3 2]",
+		"/ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode",
+		"/Filter /FlateDecode",
+		"/Title (Chapter 2)",
+		"%%EOF",
+	} {
+		if !strings.Contains(pdf, want) {
+			t.Errorf("PDF is missing %q", want)
+		}
+	}
+	if !bytes.Contains(data, jpg.Bytes()) {
+		t.Errorf("JPEG page should be embedded unchanged")
+	}
+
+	// Every cross-reference entry must point at its object.
+	start := strings.LastIndex(pdf, "startxref\n")
+	var xref int
+	fmt.Sscanf(pdf[start+len("startxref\n"):], "%d", &xref)
+	if !strings.HasPrefix(pdf[xref:], "xref\n") {
+		t.Fatalf("startxref does not point at the xref table")
+	}
+	var count int
+	fmt.Sscanf(pdf[xref:], "xref\n0 %d\n", &count)
+	entries := strings.Split(pdf[xref:], "\n")[3 : 3+count-1]
+	for i, entry := range entries {
+		var off int
+		fmt.Sscanf(entry, "%d", &off)
+		if want := fmt.Sprintf("%d 0 obj", i+1); !strings.HasPrefix(pdf[off:], want) {
+			t.Errorf("xref entry %d points at %q", i+1, pdf[off:off+10])
+		}
+	}
+}
+
+func TestGroupVolumes(t *testing.T) {
+	var chapters []domain.Chapter
+	for i := 1; i <= 5; i++ {
+		vol := ""
+		if i <= 3 {
+			vol = "1"
+		}
+		chapters = append(chapters, domain.Chapter{Name: fmt.Sprintf("Chapter %d", i), URL: fmt.Sprint(i), Volume: vol})
+	}
+	titles := func(volumes []domain.Volume) string {
+		var parts []string
+		for _, v := range volumes {
+			parts = append(parts, fmt.Sprintf("%s:%d", v.Title, len(v.Chapters)))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", ""},
+		{"2", "Volume 1:2,Volume 2:2,Volume 3:1"},
+		{"1-2,4-5", "Volume 1:2,Chapter 3:1,Volume 2:2"},
+		{"scraped", "Volume 1:3,Chapters 4-5:2"},
+	}
+	for _, tt := range tests {
+		spec, err := config.ParseVolumes(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseVolumes(%q): %v", tt.spec, err)
+		}
+		if got := titles(GroupVolumes(spec, chapters)); got != tt.want {
+			t.Errorf("GroupVolumes(%q) = %s; want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestSelectVolumes(t *testing.T) {
+	manga := &domain.MangaDetails{}
+	for i := 1; i <= 6; i++ {
+		manga.Chapters = append(manga.Chapters, domain.Chapter{Name: fmt.Sprintf("Chapter %d", i), URL: fmt.Sprint(i)})
+	}
+	ch := func(n int) domain.Chapter { return manga.Chapters[n-1] }
+	// Volume 1 has a fetched chapter, volume 2 only one archived before and
+	// volume 3 a failed one.
+	selected := []domain.Chapter{ch(2), ch(3), ch(5)}
+	results := map[string]*domain.ChapterResult{
+		ch(2).Key(): {},
+		ch(3).Key(): {Skipped: true},
+	}
+	spec, _ := config.ParseVolumes("2")
+
+	var got []string
+	for _, v := range SelectVolumes(spec, manga, selected, results) {
+		got = append(got, fmt.Sprintf("%s:%d:%v", v.Title, len(v.Selected), v.Stale))
+	}
+	if want := []string{"Volume 1:1:true", "Volume 2:1:false"}; !slices.Equal(got, want) {
+		t.Errorf("SelectVolumes = %v; want %v", got, want)
+	}
+}
+
+func TestWriteVolume(t *testing.T) {
+	mangaDir := t.TempDir()
+	m, err := LoadManifest(mangaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol := domain.Volume{Title: "Volume 1", Number: 1}
+	for c := 1; c <= 3; c++ {
+		chapter := domain.Chapter{Name: fmt.Sprintf("Chapter %d", c), URL: fmt.Sprintf("https://example.com/c%d", c)}
+		vol.Chapters = append(vol.Chapters, chapter)
+		if c == 3 {
+			continue // never downloaded
+		}
+		dir := filepath.Join(mangaDir, chapter.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		var pages []PageState
+		for i := 1; i <= 3; i++ {
+			name := fmt.Sprintf("%03d.png", i)
+			if err := os.WriteFile(filepath.Join(dir, name), testPNG, 0644); err != nil {
+				t.Fatal(err)
+			}
+			sum, size, _ := hashFile(filepath.Join(dir, name))
+			pages = append(pages, PageState{File: name, Format: "png", Size: size, SHA256: sum, Done: true})
+		}
+		m.SetPages(chapter, pages)
+	}
+
+	manga := &domain.MangaDetails{Title: "Series", Chapters: vol.Chapters}
+	archive, err := newTestDownloader().WriteVolume(context.Background(), manga, vol, mangaDir)
+	if err != nil {
+		t.Fatalf("WriteVolume: %v", err)
+	}
+	if filepath.Base(archive) != "Volume 1.cbz" {
+		t.Errorf("archive = %s", archive)
+	}
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	var info ComicInfo
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name == ComicInfoName {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if err := xml.Unmarshal(data, &info); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Pages are numbered across the volume with the default page template.
+	want := []string{ComicInfoName,
+		"001 - Chapter 1/001.png", "001 - Chapter 1/002.png", "001 - Chapter 1/003.png",
+		"002 - Chapter 2/004.png", "002 - Chapter 2/005.png", "002 - Chapter 2/006.png"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("entries = %v; want %v", names, want)
+	}
+	if info.Volume != 1 || info.PageCount != 6 || info.Series != "Series" {
+		t.Errorf("ComicInfo = %+v", info)
+	}
+	if info.Pages[0].Bookmark != "Chapter 1" || info.Pages[3].Bookmark != "Chapter 2" || info.Pages[1].Bookmark != "" {
+		t.Errorf("chapter bookmarks = %+v", info.Pages)
+	}
+
+	if !m.IsComplete(vol.Chapters[0]) || m.IsComplete(vol.Chapters[2]) {
+		t.Errorf("only the archived chapters should be complete")
+	}
+	if _, err := os.Stat(filepath.Join(mangaDir, stageDirName, "Volume 1")); !os.IsNotExist(err) {
+		t.Errorf("stage directory left behind")
+	}
+}
+
+func TestFetchChapterRearchivesChaptersIntoVolumes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chapter/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="/img/1.jpg"><img src="/img/2.jpg"></body></html>`)
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) { w.Write(testPNG) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	scraper.Register(testSource{host: u.Host})
+
+	mangaDir := t.TempDir()
+	manga := &domain.MangaDetails{Title: "Test"}
+	for c := 1; c <= 2; c++ {
+		manga.Chapters = append(manga.Chapters, domain.Chapter{Name: fmt.Sprintf("Chapter %d", c), URL: fmt.Sprintf("%s/chapter/%d", srv.URL, c)})
+	}
+	// The chapters are first archived on their own.
+	d := newTestDownloader()
+	for _, chapter := range manga.Chapters {
+		if _, err := d.DownloadChapter(context.Background(), manga, chapter, mangaDir); err != nil {
+			t.Fatalf("DownloadChapter: %v", err)
+		}
+	}
+
+	cfg := d.cfg
+	cfg.Volumes = "2"
+	d = New(cfg, d.scraper)
+	fetch := func() map[string]*domain.ChapterResult {
+		results := make(map[string]*domain.ChapterResult)
+		for _, chapter := range manga.Chapters {
+			result, err := d.FetchChapter(context.Background(), manga, chapter, mangaDir)
+			if err != nil {
+				t.Fatalf("FetchChapter: %v", err)
+			}
+			results[chapter.Key()] = result
+		}
+		return results
+	}
+
+	spec, _ := config.ParseVolumes(cfg.Volumes)
+	volumes := SelectVolumes(spec, manga, manga.Chapters, fetch())
+	if len(volumes) != 1 || !volumes[0].Stale {
+		t.Fatalf("SelectVolumes = %+v; want one stale volume", volumes)
+	}
+	archive, err := d.WriteVolume(context.Background(), manga, volumes[0].Volume, mangaDir)
+	if err != nil {
+		t.Fatalf("WriteVolume: %v", err)
+	}
+	if filepath.Base(archive) != "Volume 1.cbz" {
+		t.Errorf("archive = %s", archive)
+	}
+
+	// Once in their volume archive, the chapters are skipped.
+	for key, result := range fetch() {
+		if !result.Skipped || result.Archive != archive {
+			t.Errorf("%s: %+v; want skipped in %s", key, result, archive)
+		}
+	}
+}
+
+// largeImage returns a PNG padded to size bytes, served by handler with
+// Range support unless ranges is false.
+func largeImage(size int, ranges bool) ([]byte, http.Handler) {
+	data := make([]byte, size)
+	copy(data, testPNG)
+	for i := len(testPNG); i < size; i++ {
+		data[i] = byte(i)
+	}
+	return data, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		w.Header().Set("Content-Type", "image/png")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+}
+
+func TestDownloadImageInChunks(t *testing.T) {
+	for _, ranges := range []bool{true, false} {
+		data, handler := largeImage(300*1024, ranges)
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+
+		dir := t.TempDir()
+		name, info, err := newTestDownloader().DownloadImageInChunks(context.Background(), srv.URL+"/page", dir, "001", scraper.RequestOptions{})
+		if err != nil {
+			t.Fatalf("ranges=%v: DownloadImageInChunks: %v", ranges, err)
+		}
+		if name != "001.png" || info.Width != 3 {
+			t.Errorf("ranges=%v: got %s %+v", ranges, name, info)
+		}
+		got, _ := os.ReadFile(filepath.Join(dir, name))
+		if !bytes.Equal(got, data) {
+			t.Errorf("ranges=%v: saved image differs from the served one", ranges)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("ranges=%v: temporary files left behind: %v", ranges, entries)
+		}
+	}
+}
+
+// BenchmarkDownloadImage downloads an 8 MB image. Bodies are streamed to
+// disk, so B/op stays far below the image size.
+func BenchmarkDownloadImage(b *testing.B) {
+	const size = 8 << 20
+	for _, ranges := range []bool{true, false} {
+		name := "single"
+		if ranges {
+			name = "chunked"
+		}
+		b.Run(name, func(b *testing.B) {
+			_, handler := largeImage(size, ranges)
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			d := newTestDownloader()
+			dir := b.TempDir()
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := d.DownloadImageInChunks(context.Background(), srv.URL+"/page", dir, "001", scraper.RequestOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
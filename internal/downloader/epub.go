@@ -0,0 +1,267 @@
+package downloader
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"mangadl/internal/atomicfile"
+)
+
+// epubPage is one image and the XHTML page that shows it.
+type epubPage struct {
+	ID, Image, XHTML, MediaType string
+	Width, Height               int
+	Title                       string // set on the first page of a section
+}
+
+// createEPUB writes b as a fixed-layout EPUB 3 comic read right to left.
+// Every page image gets its own XHTML page sized to the image; the first
+// image doubles as the cover.
+func createEPUB(dest string, b *book) (err error) {
+	var pages []epubPage
+	for _, section := range b.Sections {
+		for i, p := range section.Pages {
+			n := len(pages) + 1
+			page := epubPage{
+				ID:        fmt.Sprintf("p%04d", n),
+				Image:     fmt.Sprintf("images/%04d%s", n, strings.ToLower(filepath.Ext(p.File))),
+				XHTML:     fmt.Sprintf("pages/%04d.xhtml", n),
+				MediaType: imageMediaType(p.File),
+				Width:     p.Width,
+				Height:    p.Height,
+			}
+			if i == 0 {
+				page.Title = section.Title
+			}
+			if page.Width == 0 || page.Height == 0 {
+				if page.Width, page.Height, err = imageSize(filepath.Join(section.Dir, p.File)); err != nil {
+					return err
+				}
+			}
+			pages = append(pages, page)
+		}
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages to write")
+	}
+
+	// mimetype, container.xml, content.opf and nav.xhtml, then an XHTML
+	// page and an image per page.
+	entries := []string{"mimetype", "META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml"}
+	for _, page := range pages {
+		entries = append(entries, path.Join("OEBPS", page.XHTML), path.Join("OEBPS", page.Image))
+	}
+	f, err := atomicfile.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Finish(&err, func(f *os.File) error { return verifyZip(f, entries) })
+	w := zip.NewWriter(f)
+
+	// The mimetype entry must come first and be stored uncompressed.
+	mw, err := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mw, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	data := struct {
+		*book
+		Pages    []epubPage
+		Language string
+		Modified string
+	}{b, pages, b.Language, b.Modified.Format(time.RFC3339)}
+	if data.Language == "" {
+		data.Language = "en"
+	}
+
+	docs := []struct {
+		name string
+		tmpl *template.Template
+	}{
+		{"META-INF/container.xml", epubContainerTmpl},
+		{"OEBPS/content.opf", epubPackageTmpl},
+		{"OEBPS/nav.xhtml", epubNavTmpl},
+	}
+	for _, doc := range docs {
+		if err := writeTemplate(w, doc.name, doc.tmpl, data); err != nil {
+			return err
+		}
+	}
+
+	n := 0
+	for _, section := range b.Sections {
+		for _, p := range section.Pages {
+			page := pages[n]
+			n++
+			if err := writeTemplate(w, path.Join("OEBPS", page.XHTML), epubPageTmpl, page); err != nil {
+				return err
+			}
+			if err := copyToZip(w, path.Join("OEBPS", page.Image), filepath.Join(section.Dir, p.File)); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Close()
+}
+
+var (
+	epubContainerTmpl = epubTemplate(epubContainer)
+	epubPackageTmpl   = epubTemplate(epubPackage)
+	epubNavTmpl       = epubTemplate(epubNav)
+	epubPageTmpl      = epubTemplate(epubPageDoc)
+)
+
+func epubTemplate(text string) *template.Template {
+	return template.Must(template.New("").Funcs(template.FuncMap{"x": xmlEscape}).Parse(text))
+}
+
+// writeTemplate renders t with data into a new entry of w.
+func writeTemplate(w *zip.Writer, name string, t *template.Template, data any) error {
+	fw, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	return t.Execute(fw, data)
+}
+
+// copyToZip stores the file at src in w as name. Images are already
+// compressed, so they are stored as is.
+func copyToZip(w *zip.Writer, name, src string) error {
+	fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// imageSize decodes the dimensions of the image at path.
+func imageSize(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// imageMediaType returns the MIME type for a page file name.
+func imageMediaType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	case ".avif":
+		return "image/avif"
+	}
+	return "image/jpeg"
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	// EscapeText only fails on write errors, which a Builder never returns.
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubPackage = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{x .ID}}</dc:identifier>
+    <dc:title>{{x .Title}}</dc:title>
+    <dc:language>{{x .Language}}</dc:language>
+{{- range .Authors}}
+    <dc:creator>{{x .}}</dc:creator>
+{{- end}}
+{{- range .Genres}}
+    <dc:subject>{{x .}}</dc:subject>
+{{- end}}
+{{- if .Summary}}
+    <dc:description>{{x .Summary}}</dc:description>
+{{- end}}
+{{- if .Series}}
+    <meta property="belongs-to-collection" id="series">{{x .Series}}</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+{{- end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">portrait</meta>
+    <meta property="rendition:spread">none</meta>
+    <meta name="cover" content="img-{{(index .Pages 0).ID}}"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{{- range $i, $p := .Pages}}
+    <item id="img-{{$p.ID}}" href="{{$p.Image}}" media-type="{{$p.MediaType}}"{{if eq $i 0}} properties="cover-image"{{end}}/>
+    <item id="{{$p.ID}}" href="{{$p.XHTML}}" media-type="application/xhtml+xml"/>
+{{- end}}
+  </manifest>
+  <spine page-progression-direction="rtl">
+{{- range .Pages}}
+    <itemref idref="{{.ID}}"/>
+{{- end}}
+  </spine>
+</package>
+`
+
+const epubNav = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{x .Title}}</title></head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol>
+{{- range .Pages}}{{if .Title}}
+      <li><a href="{{.XHTML}}">{{x .Title}}</a></li>
+{{- end}}{{end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`
+
+const epubPageDoc = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>{{if .Title}}{{x .Title}}{{else}}{{.ID}}{{end}}</title>
+  <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: 100%; height: 100%; }</style>
+</head>
+<body>
+  <img src="../{{.Image}}" alt="{{.ID}}"/>
+</body>
+</html>
+`
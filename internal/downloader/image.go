@@ -0,0 +1,166 @@
+package downloader
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mangadl/internal/atomicfile"
+
+	_ "golang.org/x/image/webp"
+)
+
+// ErrNotImage is returned when a page response is not an image, such as an
+// HTML error page served with status 200 or an empty body.
+var ErrNotImage = errors.New("response is not an image")
+
+// ImageInfo describes a downloaded page image.
+type ImageInfo struct {
+	Format string // "jpeg", "png", "gif", "webp" or "avif"
+	Width  int    // 0 if the dimensions could not be decoded
+	Height int
+}
+
+// Ext returns the file extension for the format, including the dot.
+func (i ImageInfo) Ext() string {
+	if i.Format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + i.Format
+}
+
+// imageContentTypes maps image MIME types to formats, for images whose magic
+// bytes are not recognised.
+var imageContentTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/jpg":  "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+	"image/avif": "avif",
+}
+
+// detectImage identifies the format of the image read from r by its magic
+// bytes, falling back to the Content-Type header, and decodes its dimensions
+// where possible. Only the image header is read. Empty bodies, HTML and
+// other non-image responses are rejected.
+func detectImage(r io.Reader, contentType string) (ImageInfo, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ImageInfo{}, err
+	}
+	head = head[:n]
+	if len(head) == 0 {
+		return ImageInfo{}, fmt.Errorf("%w: empty body", ErrNotImage)
+	}
+
+	info := ImageInfo{Format: sniffFormat(head)}
+	if info.Format == "" {
+		// Trust the header only for bodies that do not look like text, so
+		// an HTML error page labelled image/jpeg is still rejected.
+		sniffed := http.DetectContentType(head)
+		if strings.HasPrefix(sniffed, "text/") {
+			return ImageInfo{}, fmt.Errorf("%w: got %s", ErrNotImage, sniffed)
+		}
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if info.Format = imageContentTypes[mediaType]; info.Format == "" {
+			return ImageInfo{}, fmt.Errorf("%w: got %s", ErrNotImage, cmp.Or(contentType, sniffed))
+		}
+	}
+
+	if cfg, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head), r)); err == nil {
+		info.Width, info.Height = cfg.Width, cfg.Height
+	}
+	return info, nil
+}
+
+// sniffFormat returns the image format identified by the magic bytes of
+// data, or "" if it is not a known image format.
+func sniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis"):
+		return "avif"
+	}
+	return ""
+}
+
+// partSuffix marks a page that is still being written.
+const partSuffix = atomicfile.Suffix
+
+// savePage writes a page to dir as base plus the extension of its detected
+// format. write fills a temporary file, pre-allocated to size bytes if size
+// is positive, which is validated as an image, synced and renamed into place
+// once complete, so a page file is never left half written. Files left for the
+// same page under another extension are removed so the archive holds a
+// single copy.
+func savePage(dir, base, contentType string, size int64, write func(f *os.File) error) (name string, info ImageInfo, err error) {
+	part := filepath.Join(dir, base+partSuffix)
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", info, err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(part)
+		}
+	}()
+	if size > 0 {
+		if err := f.Truncate(size); err != nil {
+			return "", info, err
+		}
+	}
+	if err := write(f); err != nil {
+		return "", info, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", info, err
+	}
+	if info, err = detectImage(f, contentType); err != nil {
+		return "", info, err
+	}
+	if err := f.Sync(); err != nil {
+		return "", info, err
+	}
+	if err := f.Close(); err != nil {
+		return "", info, err
+	}
+
+	name = base + info.Ext()
+	if err := os.Rename(part, filepath.Join(dir, name)); err != nil {
+		return "", info, err
+	}
+	atomicfile.SyncDir(dir)
+	stale, _ := filepath.Glob(filepath.Join(dir, globEscape(base)+".*"))
+	for _, path := range stale {
+		if filepath.Base(path) != name {
+			os.Remove(path)
+		}
+	}
+	return name, info, nil
+}
+
+// globEscape quotes the pattern characters in a file name for filepath.Glob.
+func globEscape(name string) string {
+	return strings.NewReplacer(`[`, `[[]`, `*`, `[*]`, `?`, `[?]`).Replace(name)
+}
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"strings"
+
+	"mangadl/internal/config"
+	"mangadl/internal/domain"
+)
+
+// SeriesDir returns the directory a series is written to: the series
+// template rendered under cfg.OutputDir. cfg is expected to have passed
+// Validate.
+func SeriesDir(cfg config.Config, manga *domain.MangaDetails) (string, error) {
+	t, err := cfg.Templates.Parse()
+	if err != nil {
+		return "", err
+	}
+	rel, err := t.Series.Execute(seriesValues(manga))
+	if err != nil {
+		return "", err
+	}
+	return joinWithin(cfg.OutputDir, sanitizePath(rel))
+}
+
+// chapterDir returns the chapter directory, slash-separated and relative to
+// the series directory, as recorded in manifest. The archive is written
+// next to it with the format extension. A chapter seen for the first time
+// claims the rendered chapter template, made unique among the chapters of
+// the series.
+func (d *Downloader) chapterDir(manifest *Manifest, manga *domain.MangaDetails, chapter domain.Chapter) (string, error) {
+	if st, ok := manifest.Chapter(chapter); ok && st.Dir != "" {
+		return st.Dir, nil
+	}
+	rel, err := d.templates.Chapter.Execute(chapterValues(manga, chapter))
+	if err != nil {
+		return "", err
+	}
+	return manifest.ClaimDir(chapter, sanitizePath(rel)), nil
+}
+
+// pageBase returns the file name, without extension, of the 1-based page
+// index of chapter.
+func (d *Downloader) pageBase(manga *domain.MangaDetails, chapter domain.Chapter, index int) (string, error) {
+	values := chapterValues(manga, chapter)
+	values["page"] = index
+	name, err := d.templates.Page.Execute(values)
+	return SanitizeFilename(name), err
+}
+
+// volumePath returns the volume archive path without extension,
+// slash-separated and relative to the series directory. {volume} falls back
+// to the title for runs of chapters that are not a numbered volume.
+func (d *Downloader) volumePath(manga *domain.MangaDetails, vol domain.Volume) (string, error) {
+	values := seriesValues(manga)
+	values["volume_title"] = sanitizeValue(vol.Title)
+	values["volume"] = values["volume_title"]
+	if vol.Number > 0 {
+		values["volume"] = vol.Number
+	}
+	rel, err := d.templates.Volume.Execute(values)
+	return sanitizePath(rel), err
+}
+
+// seriesValues returns the sanitized template values describing manga,
+// which may be nil.
+func seriesValues(manga *domain.MangaDetails) map[string]any {
+	values := make(map[string]any)
+	if manga != nil {
+		values["series"] = sanitizeValue(manga.Title)
+		values["source"] = sanitizeValue(manga.Source)
+	}
+	return values
+}
+
+// chapterValues returns the sanitized template values describing chapter.
+func chapterValues(manga *domain.MangaDetails, chapter domain.Chapter) map[string]any {
+	values := seriesValues(manga)
+	values["chapter"] = sanitizeValue(chapter.Name)
+	num := chapter.ParsedNumber()
+	values["chapter_number"] = num
+	if !num.Numbered {
+		values["chapter_number"] = 0
+	}
+	if num.Kind != domain.KindRegular {
+		values["chapter_kind"] = num.Kind.String()
+	}
+	values["chapter_title"] = sanitizeValue(chapterTitle(chapter.Name))
+	values["volume"] = sanitizeValue(chapter.Volume)
+	return values
+}
+
+// sanitizeValue sanitizes a template value, keeping empty values empty so
+// the segments they fill are dropped.
+func sanitizeValue(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	return SanitizeFilename(s)
+}
@@ -0,0 +1,276 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"mangadl/internal/atomicfile"
+	"mangadl/internal/domain"
+)
+
+// ManifestName is the file, inside each manga directory, that records
+// download state so interrupted runs can be resumed.
+const ManifestName = ".mangadl-manifest.json"
+
+// manifestSaveInterval throttles saves while pages are completing.
+const manifestSaveInterval = time.Second
+
+// PageState records one downloaded page.
+type PageState struct {
+	URL    string `json:"url"`
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Done   bool   `json:"done"`
+
+	Format string `json:"format,omitempty"` // detected image format, e.g. "png"
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// ChapterState records the pages of one chapter and whether its archive
+// has been written.
+type ChapterState struct {
+	Name     string      `json:"name"`
+	URL      string      `json:"url"`
+	Dir      string      `json:"dir,omitempty"` // chapter directory, relative to the manga directory
+	Pages    []PageState `json:"pages"`
+	Archive  string      `json:"archive,omitempty"`
+	Complete bool        `json:"complete"`
+}
+
+// Manifest is the resume state of a manga directory. It is shared by every
+// chapter download writing to that directory and is safe for concurrent use.
+type Manifest struct {
+	mu        sync.Mutex
+	path      string
+	lastSaved time.Time
+	dirty     bool
+
+	Chapters map[string]*ChapterState `json:"chapters"` // keyed by domain.Chapter.Key
+}
+
+var (
+	manifestsMu sync.Mutex
+	manifests   = make(map[string]*Manifest)
+)
+
+// LoadManifest returns the manifest for mangaDir, reading it from disk the
+// first time. A missing manifest yields an empty one. The first load also
+// removes partial files and staged pages left in mangaDir by an interrupted
+// run.
+func LoadManifest(mangaDir string) (*Manifest, error) {
+	path, err := filepath.Abs(filepath.Join(mangaDir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	manifestsMu.Lock()
+	defer manifestsMu.Unlock()
+	if m, ok := manifests[path]; ok {
+		return m, nil
+	}
+
+	if err := cleanStale(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{path: path, Chapters: make(map[string]*ChapterState)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("corrupt manifest %s: %w", path, err)
+		}
+		if m.Chapters == nil {
+			m.Chapters = make(map[string]*ChapterState)
+		}
+	}
+	manifests[path] = m
+	return m, nil
+}
+
+// entryLocked returns the state recorded for chapter. Manifests written
+// before chapters had IDs are keyed by URL; such an entry is moved to the
+// chapter's key when it is first looked up.
+func (m *Manifest) entryLocked(chapter domain.Chapter) (*ChapterState, bool) {
+	key := chapter.Key()
+	if st, ok := m.Chapters[key]; ok {
+		return st, true
+	}
+	st, ok := m.Chapters[chapter.URL]
+	if !ok || st.URL != chapter.URL {
+		return nil, false
+	}
+	delete(m.Chapters, chapter.URL)
+	m.Chapters[key] = st
+	m.dirty = true
+	return st, true
+}
+
+// Chapter returns a copy of the recorded state for chapter.
+func (m *Manifest) Chapter(chapter domain.Chapter) (ChapterState, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.entryLocked(chapter)
+	if !ok {
+		return ChapterState{}, false
+	}
+	cp := *st
+	cp.Pages = append([]PageState(nil), st.Pages...)
+	return cp, true
+}
+
+// IsComplete reports whether chapter was fully downloaded and archived, and
+// the archive is still on disk.
+func (m *Manifest) IsComplete(chapter domain.Chapter) bool {
+	st, ok := m.Chapter(chapter)
+	if !ok || !st.Complete || st.Archive == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(filepath.Dir(m.path), st.Archive))
+	return err == nil
+}
+
+// SetPages records the page list of a chapter, resetting its completion.
+func (m *Manifest) SetPages(chapter domain.Chapter, pages []PageState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := &ChapterState{Name: chapter.Name, URL: chapter.URL, Pages: pages}
+	if old, ok := m.entryLocked(chapter); ok {
+		st.Dir = old.Dir
+	}
+	m.Chapters[chapter.Key()] = st
+	m.dirty = true
+}
+
+// ClaimDir records dir, a slash-separated path relative to the manga
+// directory, as the directory of chapter and returns it. A chapter keeps
+// the directory it claimed first, so its files stay put across runs. If
+// another chapter already uses dir, compared case-insensitively as on
+// Windows and macOS, a " (2)", " (3)", ... suffix is added.
+func (m *Manifest) ClaimDir(chapter domain.Chapter, dir string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.entryLocked(chapter)
+	if ok && st.Dir != "" {
+		return st.Dir
+	}
+	if !ok {
+		st = &ChapterState{Name: chapter.Name, URL: chapter.URL}
+		m.Chapters[chapter.Key()] = st
+	}
+
+	taken := func(dir string) bool {
+		for _, other := range m.Chapters {
+			if other != st && strings.EqualFold(other.Dir, dir) {
+				return true
+			}
+		}
+		return false
+	}
+	claimed := dir
+	for n := 2; taken(claimed); n++ {
+		claimed = fmt.Sprintf("%s (%d)", dir, n)
+	}
+	st.Dir = claimed
+	m.dirty = true
+	return claimed
+}
+
+// MarkPage records the state of page idx and saves the manifest if the
+// last save is older than manifestSaveInterval.
+func (m *Manifest) MarkPage(chapter domain.Chapter, idx int, page PageState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.entryLocked(chapter)
+	if !ok || idx < 0 || idx >= len(st.Pages) {
+		return fmt.Errorf("page %d of %s not in manifest", idx, chapter.Name)
+	}
+	st.Pages[idx] = page
+	m.dirty = true
+	if time.Since(m.lastSaved) < manifestSaveInterval {
+		return nil
+	}
+	return m.saveLocked()
+}
+
+// MarkComplete records that the chapter archive has been written.
+func (m *Manifest) MarkComplete(chapter domain.Chapter, archive string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.entryLocked(chapter)
+	if !ok {
+		return fmt.Errorf("chapter %s not in manifest", chapter.Name)
+	}
+	st.Archive = archive
+	st.Complete = true
+	m.dirty = true
+	return m.saveLocked()
+}
+
+// Save writes the manifest to disk if it has unsaved changes.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveLocked()
+}
+
+func (m *Manifest) saveLocked() error {
+	if !m.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(m.path, data); err != nil {
+		return err
+	}
+	m.dirty = false
+	m.lastSaved = time.Now()
+	return nil
+}
+
+// verifyPage reports whether the page file on disk matches its record.
+func verifyPage(dir string, page PageState) bool {
+	if !page.Done || page.File == "" {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, page.File))
+	if err != nil || info.Size() != page.Size {
+		return false
+	}
+	sum, _, err := hashFile(filepath.Join(dir, page.File))
+	return err == nil && sum == page.SHA256
+}
+
+// hashFile returns the hex SHA-256 and size of a file.
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
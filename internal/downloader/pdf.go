@@ -0,0 +1,244 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"mangadl/internal/atomicfile"
+)
+
+// pdfImage is a page image ready to embed as an XObject.
+type pdfImage struct {
+	Width, Height int
+	ColorSpace    string // DeviceGray, DeviceRGB or DeviceCMYK
+	Filter        string // DCTDecode or FlateDecode
+	Decode        string // optional Decode array, for Adobe CMYK JPEGs
+	Data          []byte
+}
+
+// createPDF writes b as a PDF with one page per image at the image's native
+// size (one pixel per point). JPEG pages are embedded unchanged; other formats
+// are decoded and stored losslessly. Each section gets a bookmark, and the
+// document is marked for right-to-left reading.
+func createPDF(dest string, b *book) (err error) {
+	type pdfPage struct {
+		dir  string
+		page PageState
+	}
+	type bookmark struct {
+		title string
+		page  int // index of the section's first page
+	}
+	var (
+		pages   []pdfPage
+		outline []bookmark
+	)
+	for _, section := range b.Sections {
+		if len(section.Pages) == 0 {
+			continue
+		}
+		outline = append(outline, bookmark{section.Title, len(pages)})
+		for _, p := range section.Pages {
+			pages = append(pages, pdfPage{section.Dir, p})
+		}
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages to write")
+	}
+
+	f, err := atomicfile.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Finish(&err, nil)
+	w := &pdfWriter{w: bufio.NewWriter(f)}
+
+	// Objects 1-4 are fixed; each page then takes three objects (page,
+	// content stream, image), followed by one per bookmark.
+	const catalog, pageTree, info, outlines = 1, 2, 3, 4
+	pageObj := func(i int) int { return 5 + 3*i }
+	bookmarkObj := func(i int) int { return 5 + 3*len(pages) + i }
+	w.offsets = make([]int64, bookmarkObj(len(outline)))
+
+	w.printf("%%PDF-1.7\n%%\xe2\xe3\xcf\xd3\n")
+
+	w.object(catalog, "<< /Type /Catalog /Pages %d 0 R /Outlines %d 0 R /PageMode /UseOutlines"+
+		" /ViewerPreferences << /Direction /R2L >> /Lang %s >>", pageTree, outlines, pdfString(b.Language))
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj(i))
+	}
+	w.object(pageTree, "<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	w.object(info, "<< /Title %s /Author %s /Subject %s /Keywords %s /Creator (mangadl) /Producer (mangadl) >>",
+		pdfString(b.Title), pdfString(strings.Join(b.Authors, ", ")),
+		pdfString(b.Summary), pdfString(strings.Join(b.Genres, ", ")))
+
+	w.object(outlines, "<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+		bookmarkObj(0), bookmarkObj(len(outline)-1), len(outline))
+
+	for i, p := range pages {
+		img, err := loadPDFImage(filepath.Join(p.dir, p.page.File))
+		if err != nil {
+			return err
+		}
+		obj := pageObj(i)
+		w.object(obj, "<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Contents %d 0 R"+
+			" /Resources << /XObject << /Im0 %d 0 R >> >> >>",
+			pageTree, img.Width, img.Height, obj+1, obj+2)
+		w.stream(obj+1, "", []byte(fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", img.Width, img.Height)))
+		w.stream(obj+2, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s"+
+			" /BitsPerComponent 8 /Filter /%s%s", img.Width, img.Height, img.ColorSpace, img.Filter, img.Decode), img.Data)
+	}
+
+	for i, mark := range outline {
+		links := ""
+		if i > 0 {
+			links += fmt.Sprintf(" /Prev %d 0 R", bookmarkObj(i-1))
+		}
+		if i < len(outline)-1 {
+			links += fmt.Sprintf(" /Next %d 0 R", bookmarkObj(i+1))
+		}
+		w.object(bookmarkObj(i), "<< /Title %s /Parent %d 0 R%s /Dest [%d 0 R /Fit] >>",
+			pdfString(mark.title), outlines, links, pageObj(mark.page))
+	}
+
+	xref := w.n
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", len(w.offsets))
+	for _, off := range w.offsets[1:] {
+		w.printf("%010d 00000 n \n", off)
+	}
+	w.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets), catalog, info, xref)
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// pdfWriter writes numbered objects and records their offsets for the
+// cross-reference table. The first write error is kept in err.
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int64
+	offsets []int64 // indexed by object number; 0 is the free entry
+	err     error
+}
+
+func (w *pdfWriter) printf(format string, args ...any) {
+	w.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+func (w *pdfWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *pdfWriter) object(num int, format string, args ...any) {
+	w.offsets[num] = w.n
+	w.printf("%d 0 obj\n"+format+"\nendobj\n", append([]any{num}, args...)...)
+}
+
+func (w *pdfWriter) stream(num int, dict string, data []byte) {
+	w.offsets[num] = w.n
+	w.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", num, dict, len(data))
+	w.write(data)
+	w.printf("\nendstream\nendobj\n")
+}
+
+// loadPDFImage reads a page image. JPEGs are passed through as DCTDecode;
+// other images are decoded, flattened onto white and Flate-compressed.
+func loadPDFImage(path string) (*pdfImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if sniffFormat(data) == "jpeg" {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		img := &pdfImage{Width: cfg.Width, Height: cfg.Height, ColorSpace: "DeviceRGB", Filter: "DCTDecode", Data: data}
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			img.ColorSpace = "DeviceGray"
+		case color.CMYKModel:
+			// Go only decodes Adobe CMYK JPEGs, which store inverted values.
+			img.ColorSpace, img.Decode = "DeviceCMYK", " /Decode [1 0 1 0 1 0 1 0]"
+		}
+		return img, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	bounds := src.Bounds()
+	img := &pdfImage{Width: bounds.Dx(), Height: bounds.Dy(), Filter: "FlateDecode"}
+
+	var pixels []byte
+	if gray, ok := src.(*image.Gray); ok {
+		img.ColorSpace = "DeviceGray"
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := gray.Pix[(y-bounds.Min.Y)*gray.Stride:]
+			pixels = append(pixels, row[:bounds.Dx()]...)
+		}
+	} else {
+		img.ColorSpace = "DeviceRGB"
+		rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Over)
+		pixels = make([]byte, 0, 3*bounds.Dx()*bounds.Dy())
+		for i := 0; i < len(rgba.Pix); i += 4 {
+			pixels = append(pixels, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(pixels); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	img.Data = buf.Bytes()
+	return img, nil
+}
+
+// pdfString encodes s as a PDF text string: a literal string for ASCII,
+// UTF-16BE hex otherwise.
+func pdfString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+		return "(" + r.Replace(s) + ")"
+	}
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
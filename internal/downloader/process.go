@@ -0,0 +1,349 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+
+	"mangadl/internal/config"
+)
+
+const (
+	// whiteThreshold is the luminance above which a pixel counts as margin.
+	whiteThreshold = 240
+	// minJPEGQuality is the lowest quality used to meet a device size limit.
+	minJPEGQuality = 40
+)
+
+// pipeline turns downloaded pages into the pages that go into the archive.
+type pipeline struct {
+	convert   config.ConvertConfig
+	device    config.DeviceProfile
+	hasDevice bool
+	grayscale bool
+}
+
+// newPipeline returns the page pipeline for cfg, or nil if pages are archived
+// as downloaded.
+func newPipeline(cfg config.Config) (*pipeline, error) {
+	if !cfg.Convert.Enabled() {
+		return nil, nil
+	}
+	device, ok, err := cfg.Device()
+	if err != nil {
+		return nil, err
+	}
+	return &pipeline{
+		convert:   cfg.Convert,
+		device:    device,
+		hasDevice: ok,
+		grayscale: cfg.Convert.Grayscale || (ok && device.Grayscale),
+	}, nil
+}
+
+// processPages runs every page in srcDir through the pipeline and writes the
+// results to destDir. It returns the archived pages in reading order; a split
+// spread yields two of them. The downloaded pages are left untouched, so the
+// manifest stays valid and the stage can be rebuilt at any time.
+func (p *pipeline) processPages(srcDir, destDir string, pages []PageState) ([]PageState, error) {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	out := make([][]PageState, len(pages))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i, page := range pages {
+		wg.Add(1)
+		go func(idx int, page PageState) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			processed, err := p.processPage(srcDir, destDir, page)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("page %d: %w", idx+1, err)
+				}
+				return
+			}
+			out[idx] = processed
+		}(i, page)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var result []PageState
+	for _, parts := range out {
+		result = append(result, parts...)
+	}
+	return result, nil
+}
+
+// processPage converts one downloaded page into one or two archive pages.
+// Pages Go cannot decode (AVIF) are copied unchanged.
+func (p *pipeline) processPage(srcDir, destDir string, page PageState) ([]PageState, error) {
+	data, err := os.ReadFile(filepath.Join(srcDir, page.File))
+	if err != nil {
+		return nil, err
+	}
+	if !canDecode(page.Format) {
+		return p.writePage(destDir, page.File, data, page)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", page.File, err)
+	}
+	original := img
+
+	if p.grayscale {
+		img = toGray(img)
+	}
+	if p.convert.CropMargins {
+		img = cropMargins(img)
+	}
+
+	parts := []image.Image{img}
+	if b := img.Bounds(); b.Dx() > b.Dy() {
+		switch p.convert.Spreads {
+		case config.SpreadsSplit:
+			parts = splitSpread(img)
+		case config.SpreadsRotate:
+			parts = []image.Image{rotate90(img)}
+		}
+	}
+
+	target := p.convert.Format
+	if target == "" {
+		target = page.Format
+	}
+	if !canEncode(target) {
+		target = "jpeg"
+	}
+
+	// Pages keep the downloaded name; only the extension may change.
+	base := strings.TrimSuffix(page.File, filepath.Ext(page.File))
+	var result []PageState
+	for i, part := range parts {
+		if p.hasDevice {
+			part = fitWithin(part, p.device.Width, p.device.Height)
+		}
+		if part == original && target == page.Format && !p.convert.StripMetadata {
+			// Nothing changed; avoid a lossy re-encode.
+			return p.writePage(destDir, page.File, data, page)
+		}
+		encoded, err := p.encode(part, target)
+		if err != nil {
+			return nil, err
+		}
+		info := ImageInfo{Format: target}
+		name := base + info.Ext()
+		if len(parts) > 1 {
+			name = fmt.Sprintf("%s-%d%s", base, i+1, info.Ext())
+		}
+		b := part.Bounds()
+		written, err := p.writePage(destDir, name, encoded, PageState{
+			URL: page.URL, Format: target, Width: b.Dx(), Height: b.Dy(), Done: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, written...)
+	}
+	return result, nil
+}
+
+// writePage writes data as name in dir and returns the page state for it.
+func (p *pipeline) writePage(dir, name string, data []byte, page PageState) ([]PageState, error) {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	page.File = name
+	var err error
+	page.SHA256, page.Size, err = hashFile(path)
+	return []PageState{page}, err
+}
+
+// encode renders img as format. JPEG quality is lowered step by step until
+// the page fits the device's size limit.
+func (p *pipeline) encode(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		quality := p.convert.Quality
+		for {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, err
+			}
+			limit := p.device.MaxFileSize
+			if !p.hasDevice || limit == 0 || int64(buf.Len()) <= limit || quality <= minJPEGQuality {
+				return buf.Bytes(), nil
+			}
+			quality = max(quality-10, minJPEGQuality)
+		}
+	case "png":
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := enc.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	case "gif":
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot encode %s", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// canDecode reports whether pages in format can be decoded in pure Go.
+func canDecode(format string) bool {
+	switch format {
+	case "jpeg", "png", "gif", "webp":
+		return true
+	}
+	return false
+}
+
+// canEncode reports whether pages can be written in format with the
+// standard library encoders.
+func canEncode(format string) bool {
+	switch format {
+	case "jpeg", "png", "gif":
+		return true
+	}
+	return false
+}
+
+// toGray returns img converted to 8-bit grayscale.
+func toGray(img image.Image) image.Image {
+	if g, ok := img.(*image.Gray); ok {
+		return g
+	}
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return gray
+}
+
+// subImager is implemented by every image type the standard decoders return.
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// cropMargins trims rows and columns that are almost entirely white from
+// the edges of img. Pages that are blank or nearly so are left alone.
+func cropMargins(img image.Image) image.Image {
+	sub, ok := img.(subImager)
+	if !ok {
+		return img
+	}
+	b := img.Bounds()
+	blankRow := func(y int) bool { return isBlank(img, b.Min.X, b.Max.X, y, y+1) }
+	blankCol := func(x int) bool { return isBlank(img, x, x+1, b.Min.Y, b.Max.Y) }
+
+	top, bottom := b.Min.Y, b.Max.Y
+	for top < bottom && blankRow(top) {
+		top++
+	}
+	for bottom > top && blankRow(bottom-1) {
+		bottom--
+	}
+	left, right := b.Min.X, b.Max.X
+	for left < right && blankCol(left) {
+		left++
+	}
+	for right > left && blankCol(right-1) {
+		right--
+	}
+
+	crop := image.Rect(left, top, right, bottom)
+	if crop.Dx() < b.Dx()/3 || crop.Dy() < b.Dy()/3 {
+		return img
+	}
+	return sub.SubImage(crop)
+}
+
+// isBlank reports whether the area holds almost no dark pixels. A few are
+// tolerated so scan noise and page numbers in the margin do not stop a crop.
+func isBlank(img image.Image, x0, x1, y0, y1 int) bool {
+	tolerance := max((x1-x0)*(y1-y0)/200, 1)
+	dark := 0
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < whiteThreshold {
+				if dark++; dark >= tolerance {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// splitSpread cuts a double-page spread in two, right half first as manga
+// is read right to left.
+func splitSpread(img image.Image) []image.Image {
+	sub, ok := img.(subImager)
+	if !ok {
+		return []image.Image{img}
+	}
+	b := img.Bounds()
+	mid := b.Min.X + b.Dx()/2
+	return []image.Image{
+		sub.SubImage(image.Rect(mid, b.Min.Y, b.Max.X, b.Max.Y)),
+		sub.SubImage(image.Rect(b.Min.X, b.Min.Y, mid, b.Max.Y)),
+	}
+}
+
+// rotate90 turns img 90 degrees clockwise.
+func rotate90(img image.Image) image.Image {
+	b := img.Bounds()
+	var dst draw.Image = image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(dst.Bounds())
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(b.Max.Y-1-y, x-b.Min.X, img.At(x, y))
+		}
+	}
+	return dst
+}
+
+// fitWithin downscales img to fit width x height, keeping its aspect ratio.
+// Smaller images are returned unchanged.
+func fitWithin(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width && b.Dy() <= height {
+		return img
+	}
+	scale := min(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	rect := image.Rect(0, 0, max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1))
+
+	var dst draw.Image = image.NewRGBA(rect)
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(rect)
+	}
+	draw.CatmullRom.Scale(dst, rect, img, b, draw.Src, nil)
+	return dst
+}
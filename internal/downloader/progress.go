@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mangadl/internal/domain"
+)
+
+// ProgressFunc receives progress updates for a chapter download. It is
+// called from the downloading goroutines, so it must be safe for concurrent
+// use and return quickly.
+type ProgressFunc func(domain.ChapterProgress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes DownloadChapter and
+// FetchChapter report the pages and bytes of the chapter they download to
+// fn: when the page list is known, after every page, and at most every
+// progressInterval while bytes arrive. Bytes of failed attempts are taken
+// back out, so Bytes counts what ends up on disk.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+type trackerKey struct{}
+
+// progressInterval throttles byte-level updates.
+const progressInterval = 250 * time.Millisecond
+
+// progressTracker accumulates the progress of one chapter.
+type progressTracker struct {
+	fn  ProgressFunc
+	now func() time.Time
+
+	mu         sync.Mutex
+	p          domain.ChapterProgress
+	lastReport time.Time
+	lastBytes  int64 // Bytes at lastReport, for the speed
+}
+
+// startProgress returns ctx carrying a tracker for a chapter of total
+// pages, done of which are already on disk, and reports the starting
+// point. Without a ProgressFunc in ctx the tracker only counts bytes.
+func startProgress(ctx context.Context, total, done int) (context.Context, *progressTracker) {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	t := &progressTracker{fn: fn, now: time.Now}
+	t.p.PagesTotal, t.p.PagesDone = total, done
+	t.lastReport = t.now()
+	if t.fn != nil {
+		t.fn(t.p)
+	}
+	return context.WithValue(ctx, trackerKey{}, t), t
+}
+
+// trackerFrom returns the tracker of the chapter downloading under ctx, or
+// nil.
+func trackerFrom(ctx context.Context) *progressTracker {
+	t, _ := ctx.Value(trackerKey{}).(*progressTracker)
+	return t
+}
+
+// addBytes records n received bytes and reports them if the last report is
+// older than progressInterval.
+func (t *progressTracker) addBytes(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Bytes += int64(n)
+	if t.fn != nil && t.now().Sub(t.lastReport) >= progressInterval {
+		t.reportLocked()
+	}
+}
+
+// removeBytes takes back n bytes of an abandoned attempt. The next report
+// follows the next bytes or page.
+func (t *progressTracker) removeBytes(n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Bytes -= n
+	t.lastBytes = min(t.lastBytes, t.p.Bytes)
+}
+
+// bytes returns the bytes received so far.
+func (t *progressTracker) bytes() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.p.Bytes
+}
+
+// pageDone records a finished page and reports it.
+func (t *progressTracker) pageDone() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.PagesDone++
+	if t.fn != nil {
+		t.reportLocked()
+	}
+}
+
+// reportLocked calls fn, under the lock so reports arrive in order. The
+// speed is updated at most every progressInterval and smoothed so one slow
+// page does not make it jump.
+func (t *progressTracker) reportLocked() {
+	now := t.now()
+	if elapsed := now.Sub(t.lastReport); elapsed >= progressInterval {
+		rate := float64(t.p.Bytes-t.lastBytes) / elapsed.Seconds()
+		if t.p.Speed == 0 {
+			t.p.Speed = rate
+		} else {
+			t.p.Speed = 0.7*t.p.Speed + 0.3*rate
+		}
+		t.lastReport, t.lastBytes = now, t.p.Bytes
+	}
+	t.fn(t.p)
+}
+
+type attemptKey struct{}
+
+// pageAttempt counts the bytes received by one attempt at a page, so they
+// can be taken out of the chapter progress again if the attempt fails or
+// its data is fetched again another way.
+type pageAttempt struct {
+	t *progressTracker
+	n atomic.Int64
+}
+
+// startAttempt returns ctx carrying a new attempt at a page of the chapter
+// tracked in ctx.
+func startAttempt(ctx context.Context) (context.Context, *pageAttempt) {
+	a := &pageAttempt{t: trackerFrom(ctx)}
+	return context.WithValue(ctx, attemptKey{}, a), a
+}
+
+// addBytes records n received bytes in the attempt under ctx, or directly
+// in the chapter progress when there is none.
+func addBytes(ctx context.Context, n int) {
+	a, _ := ctx.Value(attemptKey{}).(*pageAttempt)
+	if a == nil {
+		trackerFrom(ctx).addBytes(n)
+		return
+	}
+	a.n.Add(int64(max(n, 0)))
+	a.t.addBytes(n)
+}
+
+// abandonAttempt takes the bytes received so far by the attempt under ctx,
+// if any, back out of the chapter progress.
+func abandonAttempt(ctx context.Context) {
+	if a, _ := ctx.Value(attemptKey{}).(*pageAttempt); a != nil {
+		a.t.removeBytes(a.n.Swap(0))
+	}
+}
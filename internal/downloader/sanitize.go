@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxNameBytes caps a sanitized name. File systems allow 255 bytes; the rest
+// is left for extensions such as ".epub", "-2" page suffixes and " (2)"
+// collision suffixes.
+const maxNameBytes = 200
+
+// reservedNames cannot be used as file names on Windows, with or without an
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename turns name into a single path element that is valid on
+// Linux, macOS and Windows. It normalizes to Unicode NFC, replaces path
+// separators, characters Windows forbids and control characters with "_",
+// trims surrounding spaces and trailing dots, suffixes reserved device names
+// such as CON with "_", and truncates to maxNameBytes without splitting a
+// character. The result is never empty, "." or "..".
+func SanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, norm.NFC.String(name))
+
+	name = trimName(name)
+	if len(name) > maxNameBytes {
+		cut := maxNameBytes
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = trimName(name[:cut])
+	}
+	if name == "" {
+		return "_"
+	}
+
+	stem, ext, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = stem + "_"
+		if ext != "" {
+			name += "." + ext
+		}
+	}
+	return name
+}
+
+// trimName removes the spaces and trailing dots Windows drops from names.
+func trimName(name string) string {
+	return strings.TrimRight(strings.TrimSpace(name), ". ")
+}
+
+// sanitizePath sanitizes every element of the slash-separated relative path
+// rel.
+func sanitizePath(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = SanitizeFilename(part)
+	}
+	return path.Join(parts...)
+}
+
+// joinWithin joins root and the slash-separated relative path rel, refusing
+// any rel that would resolve outside root.
+func joinWithin(root, rel string) (string, error) {
+	rel = filepath.FromSlash(rel)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("path %q leaves %s", rel, root)
+	}
+	return filepath.Join(root, rel), nil
+}
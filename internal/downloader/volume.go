@@ -0,0 +1,274 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"mangadl/internal/config"
+	"mangadl/internal/domain"
+)
+
+// GroupVolumes splits chapters, in reading order, into the volumes described
+// by spec. Consecutive chapters not covered by a range or a scraped volume
+// are grouped as "Chapters a-b". A disabled spec returns nil.
+func GroupVolumes(spec config.VolumeSpec, chapters []domain.Chapter) []domain.Volume {
+	switch {
+	case spec.Scraped:
+		return groupBy(chapters, func(c domain.Chapter) (string, int) {
+			if c.Volume == "" {
+				return "", 0
+			}
+			n, _ := strconv.Atoi(c.Volume)
+			return "Volume " + c.Volume, n
+		})
+	case len(spec.Ranges) > 0:
+		return groupBy(chapters, func(c domain.Chapter) (string, int) {
+			num := c.ParsedNumber().Float()
+			for i, r := range spec.Ranges {
+				if num >= r[0] && num <= r[1] {
+					return fmt.Sprintf("Volume %d", i+1), i + 1
+				}
+			}
+			return "", 0
+		})
+	case spec.Size > 0:
+		var volumes []domain.Volume
+		for i := 0; i < len(chapters); i += spec.Size {
+			n := len(volumes) + 1
+			volumes = append(volumes, domain.Volume{
+				Title:    fmt.Sprintf("Volume %d", n),
+				Number:   n,
+				Chapters: chapters[i:min(i+spec.Size, len(chapters))],
+			})
+		}
+		return volumes
+	}
+	return nil
+}
+
+// groupBy groups consecutive chapters that key assigns the same title.
+// Chapters with an empty title are grouped into "Chapters a-b" runs.
+func groupBy(chapters []domain.Chapter, key func(domain.Chapter) (string, int)) []domain.Volume {
+	var volumes []domain.Volume
+	var current string
+	for _, c := range chapters {
+		title, number := key(c)
+		if len(volumes) == 0 || title != current {
+			volumes = append(volumes, domain.Volume{Title: title, Number: number})
+			current = title
+		}
+		v := &volumes[len(volumes)-1]
+		v.Chapters = append(v.Chapters, c)
+	}
+	for i, v := range volumes {
+		if v.Title == "" {
+			first := v.Chapters[0].ParsedNumber().Float()
+			last := v.Chapters[len(v.Chapters)-1].ParsedNumber().Float()
+			volumes[i].Title = fmt.Sprintf("Chapters %g-%g", first, last)
+			if first == last {
+				volumes[i].Title = fmt.Sprintf("Chapter %g", first)
+			}
+		}
+	}
+	return volumes
+}
+
+// SelectedVolume is a volume holding chapters downloaded in one run.
+type SelectedVolume struct {
+	domain.Volume
+	Selected []domain.Chapter // the chapters of the volume that were downloaded
+	Stale    bool             // a chapter was fetched, so the archive must be written
+}
+
+// SelectVolumes groups the chapters of manga per spec, over the whole series
+// so volumes do not depend on the selection, and returns the volumes holding
+// chapters of selected. results holds the result of each successful chapter
+// by Chapter.Key; a volume with a selected chapter without a result is left
+// out, since it cannot be written.
+func SelectVolumes(spec config.VolumeSpec, manga *domain.MangaDetails, selected []domain.Chapter, results map[string]*domain.ChapterResult) []SelectedVolume {
+	var volumes []SelectedVolume
+	for _, vol := range GroupVolumes(spec, manga.Chapters) {
+		sv := SelectedVolume{Volume: vol}
+		failed := false
+		for _, ch := range vol.Chapters {
+			if !slices.ContainsFunc(selected, func(c domain.Chapter) bool { return c.Key() == ch.Key() }) {
+				continue
+			}
+			sv.Selected = append(sv.Selected, ch)
+			switch result := results[ch.Key()]; {
+			case result == nil:
+				failed = true
+			case !result.Skipped:
+				sv.Stale = true
+			}
+		}
+		if len(sv.Selected) > 0 && !failed {
+			volumes = append(volumes, sv)
+		}
+	}
+	return volumes
+}
+
+// WriteVolume packages the downloaded chapters of vol into one archive in
+// mangaDir. Pages are renumbered across the volume and each chapter gets its
+// own folder (CBZ) or table of contents entry (EPUB, PDF); pages are named
+// with the page template, numbered across the volume. Chapters whose
+// pages are not all on disk are left out. The included chapters are marked
+// complete with the volume archive, so later runs skip them. If ctx is
+// cancelled, no archive is written and ctx's error is returned.
+func (d *Downloader) WriteVolume(ctx context.Context, manga *domain.MangaDetails, vol domain.Volume, mangaDir string) (string, error) {
+	manifest, err := LoadManifest(mangaDir)
+	if err != nil {
+		return "", err
+	}
+	stageDir := filepath.Join(mangaDir, stageDirName, SanitizeFilename(vol.Title))
+	if err := os.RemoveAll(stageDir); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(stageDir)
+
+	var (
+		sections []bookSection
+		included []domain.Chapter
+		n        int
+	)
+	for i, chapter := range vol.Chapters {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		state, ok := manifest.Chapter(chapter)
+		if !ok || len(state.Pages) == 0 {
+			continue
+		}
+		chapterDir, err := d.chapterDir(manifest, manga, chapter)
+		if err != nil {
+			return "", err
+		}
+		srcDir, err := joinWithin(mangaDir, chapterDir)
+		if err != nil {
+			return "", err
+		}
+		if !allPagesVerified(srcDir, state.Pages) {
+			continue
+		}
+		// Pages are staged apart and then moved into the chapter folder
+		// under their volume page numbers, which may clash with the
+		// chapter's own names.
+		tmpDir := filepath.Join(stageDir, ".pages")
+		pages, err := d.stagePages(srcDir, tmpDir, state.Pages)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", chapter.Name, err)
+		}
+		dir := filepath.Join(stageDir, fmt.Sprintf("%03d - %s", i+1, SanitizeFilename(chapter.Name)))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		for j, page := range pages {
+			n++
+			base, err := d.pageBase(manga, chapter, n)
+			if err != nil {
+				return "", err
+			}
+			name := base + filepath.Ext(page.File)
+			if err := os.Rename(filepath.Join(tmpDir, page.File), filepath.Join(dir, name)); err != nil {
+				return "", err
+			}
+			pages[j].File = name
+		}
+		if err := os.RemoveAll(tmpDir); err != nil {
+			return "", err
+		}
+		sections = append(sections, bookSection{Title: chapter.Name, Dir: dir, Pages: pages})
+		included = append(included, chapter)
+	}
+	if len(sections) == 0 {
+		return "", fmt.Errorf("%s: no downloaded chapters", vol.Title)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	archiveName, err := d.volumeArchive(manga, vol)
+	if err != nil {
+		return "", err
+	}
+	archivePath, err := joinWithin(mangaDir, archiveName)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return "", err
+	}
+	b := newVolumeBook(manga, vol, sections)
+	switch d.cfg.OutputFormat {
+	case "epub":
+		err = createEPUB(archivePath, b)
+	case "pdf":
+		err = createPDF(archivePath, b)
+	default:
+		err = createCBZ(archivePath, NewVolumeComicInfo(manga, vol, sections), cbzEntries(sections, true))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	for _, chapter := range included {
+		if err := manifest.MarkComplete(chapter, archiveName); err != nil {
+			return archivePath, err
+		}
+	}
+	return archivePath, nil
+}
+
+// allPagesVerified reports whether every page is on disk and intact.
+func allPagesVerified(dir string, pages []PageState) bool {
+	for _, page := range pages {
+		if !verifyPage(dir, page) {
+			return false
+		}
+	}
+	return true
+}
+
+// newVolumeBook builds the book for a volume with one section per chapter.
+func newVolumeBook(manga *domain.MangaDetails, vol domain.Volume, sections []bookSection) *book {
+	b := &book{ID: vol.Title, Title: vol.Title, Modified: time.Now().UTC(), Sections: sections}
+	if len(vol.Chapters) > 0 {
+		b.ID = vol.Chapters[0].URL + "#" + vol.Title
+	}
+	if manga != nil {
+		b.Title = manga.Title + " - " + vol.Title
+		b.Series = manga.Title
+		b.Language = manga.Language
+		b.Summary = manga.Summary
+		b.Authors = manga.Authors
+		b.Genres = manga.Genres
+	}
+	return b
+}
+
+// volumeArchive returns the archive name of vol, relative to the series
+// directory.
+func (d *Downloader) volumeArchive(manga *domain.MangaDetails, vol domain.Volume) (string, error) {
+	volumePath, err := d.volumePath(manga, vol)
+	return volumePath + "." + d.cfg.OutputFormat, err
+}
+
+// chapterVolumeArchive returns the archive name of the volume the configured
+// spec puts chapter in, grouping the whole series as SelectVolumes does, or
+// "" if chapter is in none.
+func (d *Downloader) chapterVolumeArchive(manga *domain.MangaDetails, chapter domain.Chapter) (string, error) {
+	// Validate has already accepted the spec.
+	spec, _ := config.ParseVolumes(d.cfg.Volumes)
+	for _, vol := range GroupVolumes(spec, manga.Chapters) {
+		if slices.ContainsFunc(vol.Chapters, func(c domain.Chapter) bool { return c.Key() == chapter.Key() }) {
+			return d.volumeArchive(manga, vol)
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,222 @@
+// Package history keeps a durable record of chapter downloads. Every
+// finished attempt is appended to a JSON Lines file, so what was downloaded
+// and what failed survives the process, and failed chapters can be found
+// and downloaded again later.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"mangadl/internal/config"
+	"mangadl/internal/domain"
+)
+
+// FileName is the history file inside the data directory.
+const FileName = "history.jsonl"
+
+// Status is the outcome of a download attempt.
+type Status string
+
+const (
+	StatusDone      Status = "done"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled" // stopped by the user; its pages are kept
+)
+
+// Entry records one chapter download.
+type Entry struct {
+	Series      string    `json:"series"`
+	SeriesURL   string    `json:"series_url"`
+	Chapter     string    `json:"chapter"`
+	ChapterKey  string    `json:"chapter_key"` // domain.Chapter.Key
+	ChapterURL  string    `json:"chapter_url"`
+	Dir         string    `json:"dir"`              // series directory the chapter was saved under
+	Output      string    `json:"output,omitempty"` // archive written, if any
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Bytes       int64     `json:"bytes,omitempty"`
+	Pages       int       `json:"pages,omitempty"`
+	PagesDone   int       `json:"pages_done,omitempty"`
+	FailedPages int       `json:"failed_pages,omitempty"`
+	Retries     int       `json:"retries,omitempty"`
+	Skipped     bool      `json:"skipped,omitempty"` // already downloaded before
+	Status      Status    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Duration returns how long the attempt took.
+func (e Entry) Duration() time.Duration { return e.Finished.Sub(e.Started) }
+
+// NewEntry describes the attempt to download chapter of series into dir
+// that ran from started until now and ended with result, which may be nil,
+// and err.
+func NewEntry(series *domain.MangaDetails, dir string, chapter domain.Chapter, started time.Time, result *domain.ChapterResult, err error) Entry {
+	e := Entry{
+		Chapter:    chapter.Name,
+		ChapterKey: chapter.Key(),
+		ChapterURL: chapter.URL,
+		Dir:        dir,
+		Started:    started,
+		Finished:   time.Now(),
+		Status:     StatusDone,
+	}
+	if series != nil {
+		e.Series, e.SeriesURL = series.Title, series.URL
+	}
+	if result != nil {
+		e.Output = result.Archive
+		e.Bytes = result.Bytes
+		e.Pages = result.Total
+		e.PagesDone = result.PagesDone()
+		e.FailedPages = len(result.Failed)
+		e.Retries = result.Retries
+		e.Skipped = result.Skipped
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		e.Status = StatusCancelled
+	case err != nil:
+		e.Status, e.Error = StatusFailed, err.Error()
+	}
+	return e
+}
+
+// DefaultPath returns the history location in the data directory
+// (config.DataDir).
+func DefaultPath() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Store appends entries to a history file. It is safe for concurrent use,
+// and a nil Store records nothing.
+type Store struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the history file at path for appending, creating it and its
+// directory if needed.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: path, f: f}, nil
+}
+
+// Path returns the file the store appends to.
+func (s *Store) Path() string { return s.path }
+
+// Record appends e as one line. Each line is written with a single call,
+// so concurrent processes appending to the same file do not interleave.
+func (s *Store) Record(e Entry) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// Load reads every entry of the history file at path, oldest first. A
+// missing file yields no entries. Lines that cannot be parsed, such as one
+// cut short by a crash, are skipped.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) == nil && e.Status != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// Failed returns the entries of chapters whose latest attempt failed,
+// oldest first. A chapter that failed and was downloaded later is left out.
+func Failed(entries []Entry) []Entry {
+	type key struct{ series, chapter string }
+	latest := make(map[key]int)
+	for i, e := range entries {
+		latest[key{e.SeriesURL, e.ChapterKey}] = i
+	}
+	var failed []Entry
+	for i, e := range entries {
+		if e.Status == StatusFailed && latest[key{e.SeriesURL, e.ChapterKey}] == i {
+			failed = append(failed, e)
+		}
+	}
+	return failed
+}
+
+// BySeries groups entries by series URL, in the order each series first
+// appears.
+func BySeries(entries []Entry) [][]Entry {
+	var groups [][]Entry
+	for _, e := range entries {
+		i := slices.IndexFunc(groups, func(g []Entry) bool { return g[0].SeriesURL == e.SeriesURL })
+		if i < 0 {
+			groups = append(groups, []Entry{e})
+			continue
+		}
+		groups[i] = append(groups[i], e)
+	}
+	return groups
+}
+
+// Match finds the chapters of entries, which belong to series, in its
+// current chapter list. Entries whose chapter is no longer listed are
+// returned as missing.
+func Match(series *domain.MangaDetails, entries []Entry) (chapters []domain.Chapter, missing []Entry) {
+	for _, e := range entries {
+		i := slices.IndexFunc(series.Chapters, func(c domain.Chapter) bool { return c.Key() == e.ChapterKey })
+		if i < 0 {
+			missing = append(missing, e)
+			continue
+		}
+		chapters = append(chapters, series.Chapters[i])
+	}
+	return chapters, missing
+}
@@ -0,0 +1,111 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mangadl/internal/domain"
+)
+
+func TestRecordAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", FileName)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := &domain.MangaDetails{Title: "A", URL: "https://example.com/a"}
+	chapter := domain.Chapter{Name: "Chapter 1", URL: "https://example.com/a/c1", ID: "c1"}
+	result := &domain.ChapterResult{Chapter: chapter, Total: 3, Reused: 1, Succeeded: 2, Bytes: 300, Archive: "a/Chapter 1.cbz"}
+	started := time.Now().Add(-time.Second)
+
+	if err := s.Record(NewEntry(series, "a", chapter, started, result, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Record(NewEntry(series, "a", chapter, started, nil, errors.New("boom"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A line cut short by a crash is skipped.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"series":"A","sta`)
+	f.Close()
+
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries; want 2", len(entries))
+	}
+	done := entries[0]
+	if done.Status != StatusDone || done.ChapterKey != "c1" || done.Bytes != 300 || done.PagesDone != 3 ||
+		done.Output != "a/Chapter 1.cbz" || done.SeriesURL != series.URL || done.Duration() < time.Second {
+		t.Errorf("done entry = %+v", done)
+	}
+	if failed := entries[1]; failed.Status != StatusFailed || failed.Error != "boom" {
+		t.Errorf("failed entry = %+v", failed)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	entries, err := Load(filepath.Join(t.TempDir(), FileName))
+	if err != nil || entries != nil {
+		t.Errorf("Load of a missing file = %v, %v", entries, err)
+	}
+}
+
+func TestNewEntryCancelled(t *testing.T) {
+	e := NewEntry(nil, "dir", domain.Chapter{Name: "c"}, time.Now(), nil, context.Canceled)
+	if e.Status != StatusCancelled || e.Error != "" {
+		t.Errorf("entry = %+v; want cancelled without an error", e)
+	}
+}
+
+func TestFailed(t *testing.T) {
+	entry := func(series, chapter string, status Status) Entry {
+		return Entry{SeriesURL: series, ChapterKey: chapter, Status: status}
+	}
+	entries := []Entry{
+		entry("a", "1", StatusFailed),
+		entry("a", "2", StatusFailed),
+		entry("b", "1", StatusFailed),
+		entry("a", "1", StatusDone), // retried successfully
+		entry("a", "2", StatusCancelled),
+		entry("a", "2", StatusFailed),
+		entry("b", "2", StatusCancelled),
+	}
+	failed := Failed(entries)
+	if len(failed) != 2 || failed[0] != entries[2] || failed[1] != entries[5] {
+		t.Errorf("Failed = %+v", failed)
+	}
+
+	groups := BySeries(failed)
+	if len(groups) != 2 || groups[0][0].SeriesURL != "b" || groups[1][0].SeriesURL != "a" {
+		t.Errorf("BySeries = %+v", groups)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	series := &domain.MangaDetails{Chapters: []domain.Chapter{
+		{Name: "Chapter 1", ID: "c1"},
+		{Name: "Chapter 2", URL: "https://example.com/2"},
+	}}
+	entries := []Entry{{ChapterKey: "https://example.com/2"}, {ChapterKey: "gone"}, {ChapterKey: "c1"}}
+	chapters, missing := Match(series, entries)
+	if len(chapters) != 2 || chapters[0].Name != "Chapter 2" || chapters[1].Name != "Chapter 1" {
+		t.Errorf("chapters = %+v", chapters)
+	}
+	if len(missing) != 1 || missing[0].ChapterKey != "gone" {
+		t.Errorf("missing = %+v", missing)
+	}
+}
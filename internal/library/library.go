@@ -0,0 +1,152 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"mangadl/internal/atomicfile"
+	"mangadl/internal/domain"
+)
+
+// FileName is the library file inside the user config directory.
+const FileName = "library.json"
+
+// Series is a followed manga.
+type Series struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	OutputDir   string    `json:"output_dir"`             // manga directory chapters are written to
+	Known       []string  `json:"known_chapters"`         // keys of chapters already downloaded or skipped
+	LastChecked time.Time `json:"last_checked,omitempty"` // last successful update check
+}
+
+// Library is the persistent list of followed series.
+type Library struct {
+	path   string
+	Series []*Series `json:"series"`
+}
+
+// DefaultPath returns the library location in the user config directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mangadl", FileName), nil
+}
+
+// Load reads the library at path. A missing file yields an empty library.
+func Load(path string) (*Library, error) {
+	lib := &Library{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lib, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, lib); err != nil {
+		return nil, fmt.Errorf("corrupt library %s: %w", path, err)
+	}
+	return lib, nil
+}
+
+// Save writes the library back to the path it was loaded from.
+func (l *Library) Save() error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(l.path, data)
+}
+
+// Find returns the followed series with the given URL, or nil.
+func (l *Library) Find(seriesURL string) *Series {
+	seriesURL = normalizeURL(seriesURL)
+	for _, s := range l.Series {
+		if normalizeURL(s.URL) == seriesURL {
+			return s
+		}
+	}
+	return nil
+}
+
+// Follow adds a series, or updates its title and output directory if it is
+// already followed. It reports whether the series was newly added.
+func (l *Library) Follow(s Series) (*Series, bool) {
+	if existing := l.Find(s.URL); existing != nil {
+		existing.Title = s.Title
+		if s.OutputDir != "" {
+			existing.OutputDir = s.OutputDir
+		}
+		return existing, false
+	}
+	l.Series = append(l.Series, &s)
+	sort.Slice(l.Series, func(i, j int) bool {
+		return strings.ToLower(l.Series[i].Title) < strings.ToLower(l.Series[j].Title)
+	})
+	return &s, true
+}
+
+// Unfollow removes a series and reports whether it was followed.
+func (l *Library) Unfollow(seriesURL string) bool {
+	seriesURL = normalizeURL(seriesURL)
+	for i, s := range l.Series {
+		if normalizeURL(s.URL) == seriesURL {
+			l.Series = append(l.Series[:i], l.Series[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// MarkKnown records chapters as handled so later updates skip them. They
+// are recorded by key; an entry a chapter has under its URL, from libraries
+// written before chapters had IDs, is replaced.
+func (s *Series) MarkKnown(chapters ...domain.Chapter) {
+	known := make(map[string]bool, len(s.Known))
+	for _, k := range s.Known {
+		known[k] = true
+	}
+	for _, c := range chapters {
+		if key := c.Key(); !known[key] {
+			s.Known = append(s.Known, key)
+			known[key] = true
+		}
+		if c.URL != c.Key() && known[c.URL] {
+			s.Known = slices.DeleteFunc(s.Known, func(k string) bool { return k == c.URL })
+			delete(known, c.URL)
+		}
+	}
+}
+
+// NewChapters returns the chapters of details that are neither known to the
+// library, by key or by URL, nor reported as already on disk by onDisk.
+func (s *Series) NewChapters(details *domain.MangaDetails, onDisk func(domain.Chapter) bool) []domain.Chapter {
+	known := make(map[string]bool, len(s.Known))
+	for _, k := range s.Known {
+		known[k] = true
+	}
+	var fresh []domain.Chapter
+	for _, c := range details.Chapters {
+		if known[c.Key()] || known[c.URL] || (onDisk != nil && onDisk(c)) {
+			continue
+		}
+		fresh = append(fresh, c)
+	}
+	return fresh
+}
+
+func normalizeURL(u string) string {
+	return strings.TrimRight(strings.TrimSpace(u), "/")
+}
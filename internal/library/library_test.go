@@ -0,0 +1,85 @@
+package library
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mangadl/internal/domain"
+)
+
+func TestFollowSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	lib, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	s, added := lib.Follow(Series{URL: "https://example.com/manga/b", Title: "B", OutputDir: "output/B"})
+	if !added {
+		t.Fatalf("expected series to be added")
+	}
+	c1 := domain.Chapter{Name: "Chapter 1", URL: "https://example.com/manga/b/c1", ID: "c1"}
+	s.MarkKnown(c1, c1)
+	lib.Follow(Series{URL: "https://example.com/manga/a", Title: "A"})
+
+	if _, added := lib.Follow(Series{URL: "https://example.com/manga/b/", Title: "B2"}); added {
+		t.Errorf("following the same URL twice should update, not add")
+	}
+
+	if err := lib.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	lib, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if len(lib.Series) != 2 || lib.Series[0].Title != "A" {
+		t.Fatalf("unexpected series after reload: %+v", lib.Series)
+	}
+	b := lib.Find("https://example.com/manga/b")
+	if b == nil || b.Title != "B2" || b.OutputDir != "output/B" {
+		t.Fatalf("unexpected series B: %+v", b)
+	}
+	if !reflect.DeepEqual(b.Known, []string{"c1"}) {
+		t.Errorf("Known = %v", b.Known)
+	}
+
+	if !lib.Unfollow("https://example.com/manga/a") || lib.Find("https://example.com/manga/a") != nil {
+		t.Errorf("Unfollow failed")
+	}
+}
+
+func TestNewChapters(t *testing.T) {
+	// c1 was recorded by URL before chapters had IDs.
+	s := &Series{Known: []string{"https://example.com/c1"}}
+	details := &domain.MangaDetails{Chapters: []domain.Chapter{
+		{Name: "Chapter 1", URL: "https://example.com/c1", ID: "c1"},
+		{Name: "Chapter 2", URL: "https://example.com/c2", ID: "c2"},
+		{Name: "Chapter 3", URL: "https://example.com/c3", ID: "c3"},
+	}}
+	onDisk := func(c domain.Chapter) bool { return c.ID == "c2" }
+
+	fresh := s.NewChapters(details, onDisk)
+	if len(fresh) != 1 || fresh[0].ID != "c3" {
+		t.Errorf("NewChapters = %+v; want only c3", fresh)
+	}
+
+	s.MarkKnown(details.Chapters[0], details.Chapters[2])
+	if !reflect.DeepEqual(s.Known, []string{"c1", "c3"}) {
+		t.Errorf("Known = %v; want the URL entry replaced by IDs", s.Known)
+	}
+
+	// A re-ordered listing under new URLs still matches by ID, and a
+	// chapter added in the middle is the only new one.
+	details.Chapters = []domain.Chapter{
+		{Name: "Chapter 3", URL: "https://mirror.example.com/c3", ID: "c3"},
+		{Name: "Chapter 2.5", URL: "https://mirror.example.com/c2.5", ID: "c2.5"},
+		{Name: "Chapter 1", URL: "https://mirror.example.com/c1", ID: "c1"},
+	}
+	fresh = s.NewChapters(details, nil)
+	if len(fresh) != 1 || fresh[0].ID != "c2.5" {
+		t.Errorf("NewChapters after re-ordering = %+v; want only c2.5", fresh)
+	}
+}
@@ -0,0 +1,115 @@
+// Package logfile writes the structured log to a file that is rotated by
+// size, so a long-running TUI session or a nightly update job leaves a
+// record of what happened without the log growing without bound.
+package logfile
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"mangadl/internal/config"
+)
+
+// FileName is the log file inside the data directory.
+const FileName = "mangadl.log"
+
+// DefaultPath returns the log location in the data directory
+// (config.DataDir).
+func DefaultPath() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Writer appends to a file and rotates it once it would grow past maxSize:
+// path.1 becomes path.2 and so on, path becomes path.1, and the oldest file
+// beyond keep is removed. It is safe for concurrent use.
+type Writer struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the log file at path for appending, creating it and its
+// directory if needed. A maxSize of 0 disables rotation.
+func Open(path string, maxSize int64, keep int) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, maxSize: maxSize, keep: keep}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would not fit. A single
+// write larger than maxSize still goes into one file.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files up by one and starts a new file.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	if w.keep > 0 {
+		os.Remove(w.rotated(w.keep))
+		for i := w.keep - 1; i >= 1; i-- {
+			os.Rename(w.rotated(i), w.rotated(i+1))
+		}
+		if err := os.Rename(w.path, w.rotated(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *Writer) rotated(n int) string { return fmt.Sprintf("%s.%d", w.path, n) }
+
+// Close closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// NewLogger returns a logger writing one JSON object per record to w.
+func NewLogger(w *Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, nil))
+}
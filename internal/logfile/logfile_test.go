@@ -0,0 +1,70 @@
+package logfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", FileName)
+	w, err := Open(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// "one\ntwo\n" fits in 10 bytes; every later line starts a new file and
+	// only one old file is kept, so "one\ntwo\n" is gone.
+	want := map[string]string{
+		path:        "four\n",
+		path + ".1": "three\n",
+		path + ".2": "",
+	}
+	for p, content := range want {
+		data, err := os.ReadFile(p)
+		if content == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s exists; want it removed", p)
+			}
+			continue
+		}
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v; want %q", p, data, err, content)
+		}
+	}
+}
+
+func TestReopenAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	for range 2 {
+		w, err := Open(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		NewLogger(w).Info("started", "series", "A")
+		w.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want 2", len(lines))
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil || rec["msg"] != "started" || rec["series"] != "A" {
+		t.Errorf("record = %v, %v", rec, err)
+	}
+}
@@ -0,0 +1,183 @@
+package pathtmpl
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Template is a parsed path template such as
+// "{series}/Vol {volume}/{chapter_number:04}". Placeholders are written as
+// {name} or {name:0N}; the width zero-pads numeric values to N digits
+// before the decimal point and leaves text values unchanged. "/" separates
+// directories.
+type Template struct {
+	text  string
+	parts []part
+}
+
+type part struct {
+	literal string
+	name    string // placeholder name; empty for literal text
+	width   int
+}
+
+// Parse parses text, accepting only the placeholders in names. Templates
+// must be relative paths that cannot leave the directory they are rendered
+// in, so absolute paths and "." or ".." segments are rejected.
+func Parse(text string, names ...string) (*Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("template is empty")
+	}
+	if strings.HasPrefix(text, "/") || strings.Contains(text, `\`) {
+		return nil, fmt.Errorf("template %q must be a relative path using /", text)
+	}
+
+	t := &Template{text: text}
+	rest := text
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			t.parts = append(t.parts, part{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("template %q has an unmatched }", text)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, part{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q has an unclosed {", text)
+		}
+		p, err := parsePlaceholder(rest[open+1:open+end], names)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", text, err)
+		}
+		t.parts = append(t.parts, p)
+		rest = rest[open+end+1:]
+	}
+
+	for _, p := range t.parts {
+		if p.name == "" && strings.ContainsAny(p.literal, `<>:"|?*`) {
+			return nil, fmt.Errorf("template %q contains characters not allowed in file names", text)
+		}
+	}
+	for _, seg := range strings.Split(t.literalSkeleton(), "/") {
+		if seg == "." || seg == ".." {
+			return nil, fmt.Errorf("template %q must not contain %q segments", text, seg)
+		}
+	}
+	return t, nil
+}
+
+func parsePlaceholder(spec string, names []string) (part, error) {
+	name, format, hasFormat := strings.Cut(spec, ":")
+	p := part{name: strings.TrimSpace(name)}
+	if !slices.Contains(names, p.name) {
+		return part{}, fmt.Errorf("unknown placeholder {%s} (available: {%s})", spec, strings.Join(names, "}, {"))
+	}
+	if hasFormat {
+		width, err := strconv.Atoi(format)
+		if err != nil || width < 1 || width > 10 {
+			return part{}, fmt.Errorf("invalid width in {%s}: want a digit count such as :03", spec)
+		}
+		p.width = width
+	}
+	return p, nil
+}
+
+// literalSkeleton returns the template with every placeholder replaced by a
+// marker, to check the segments the template itself contributes.
+func (t *Template) literalSkeleton() string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.name != "" {
+			b.WriteString("\x00")
+			continue
+		}
+		b.WriteString(p.literal)
+	}
+	return b.String()
+}
+
+// Has reports whether the template uses any of the named placeholders.
+func (t *Template) Has(names ...string) bool {
+	for _, p := range t.parts {
+		if p.name != "" && slices.Contains(names, p.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the template text.
+func (t *Template) String() string { return t.text }
+
+// Execute renders the template with values, which may be strings, ints,
+// float64s or Padders; missing values render as empty text. Values are inserted as is,
+// so callers sanitize them first. Segments that render empty are dropped,
+// so "{volume}/{chapter}" becomes "{chapter}" when there is no volume. The
+// result is a slash-separated relative path; an error is returned if it
+// would be empty or leave the directory it is joined to.
+func (t *Template) Execute(values map[string]any) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.name == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		b.WriteString(format(values[p.name], p.width))
+	}
+
+	var segments []string
+	for _, seg := range strings.Split(b.String(), "/") {
+		seg = strings.TrimSpace(seg)
+		switch seg {
+		case "":
+			continue
+		case ".", "..":
+			return "", fmt.Errorf("template %q renders %q, which leaves the output directory", t.text, b.String())
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("template %q renders an empty name", t.text)
+	}
+	return path.Join(segments...), nil
+}
+
+// Padder is implemented by values that render themselves as numbers, such
+// as domain.ChapterNumber.
+type Padder interface {
+	Pad(width int) string
+}
+
+// format renders a value, zero-padding numbers to width digits before the
+// decimal point.
+func format(v any, width int) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case Padder:
+		return v.Pad(width)
+	case int:
+		return fmt.Sprintf("%0*d", width, v)
+	case float64:
+		whole, frac, _ := strings.Cut(strconv.FormatFloat(v, 'f', -1, 64), ".")
+		if len(whole) < width {
+			whole = strings.Repeat("0", width-len(whole)) + whole
+		}
+		if frac != "" {
+			return whole + "." + frac
+		}
+		return whole
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,92 @@
+package pathtmpl
+
+import (
+	"fmt"
+	"testing"
+)
+
+var names = []string{"series", "chapter", "chapter_number", "volume", "page"}
+
+func TestExecute(t *testing.T) {
+	values := map[string]any{
+		"series":         "One Piece",
+		"chapter":        "Chapter 10.5",
+		"chapter_number": 10.5,
+		"page":           7,
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{series}", "One Piece"},
+		{"{chapter_number:04} - {chapter}", "0010.5 - Chapter 10.5"},
+		{"{page:03}", "007"},
+		{"{page}", "7"},
+		{"{series}/Vol {volume}/{chapter}", "One Piece/Vol/Chapter 10.5"},
+		{"{volume}/{chapter}", "Chapter 10.5"},
+		{"{series:03}", "One Piece"},
+	}
+	for _, tt := range tests {
+		tmpl, err := Parse(tt.tmpl, names...)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.tmpl, err)
+		}
+		got, err := tmpl.Execute(values)
+		if err != nil {
+			t.Fatalf("Execute(%q): %v", tt.tmpl, err)
+		}
+		if got != tt.want {
+			t.Errorf("Execute(%q) = %q; want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+// hyphenated is a Padder that pads like a hyphen-numbered chapter such as "7-2".
+type hyphenated struct{ main, sub int }
+
+func (p hyphenated) Pad(width int) string { return fmt.Sprintf("%0*d-%d", width, p.main, p.sub) }
+
+func TestExecutePadder(t *testing.T) {
+	tmpl, err := Parse("{chapter_number:03}", names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tmpl.Execute(map[string]any{"chapter_number": hyphenated{7, 2}})
+	if err != nil || got != "007-2" {
+		t.Errorf("Execute = %q, %v; want 007-2", got, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tmpl := range []string{
+		"",
+		"/abs/{series}",
+		`{series}\{chapter}`,
+		"../{series}",
+		"{series}/../x",
+		"{unknown}",
+		"{page:x}",
+		"{series",
+		"series}",
+		"a:{series}",
+	} {
+		if _, err := Parse(tmpl, names...); err == nil {
+			t.Errorf("Parse(%q) should fail", tmpl)
+		}
+	}
+}
+
+func TestExecuteRejectsEscapes(t *testing.T) {
+	tmpl, err := Parse("{series}/{chapter}", names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, series := range []string{"..", "."} {
+		if got, err := tmpl.Execute(map[string]any{"series": series, "chapter": "c"}); err == nil {
+			t.Errorf("series %q rendered %q; want an error", series, got)
+		}
+	}
+	if got, err := tmpl.Execute(nil); err == nil {
+		t.Errorf("empty values rendered %q; want an error", got)
+	}
+}
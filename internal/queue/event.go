@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+)
+
+// EventType says what changed.
+type EventType int
+
+const (
+	EventAdded     EventType = iota // a job was queued
+	EventStarted                    // a job started an attempt
+	EventProgress                   // a running job reported progress
+	EventPaused                     // a job was paused
+	EventResumed                    // a paused job was queued again
+	EventUpdated                    // a job's priority changed
+	EventRetrying                   // an attempt failed and the job was queued again
+	EventDone                       // a job finished successfully
+	EventFailed                     // a job failed after its last attempt
+	EventCancelled                  // a job was cancelled
+	EventIdle                       // no job is queued or running
+)
+
+var eventNames = []string{"added", "started", "progress", "paused", "resumed", "updated", "retrying", "done", "failed", "cancelled", "idle"}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventNames) {
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+	return eventNames[t]
+}
+
+// Event reports a change to the queue. Job is a snapshot taken when the
+// event was published; it is the zero Job for EventIdle.
+type Event struct {
+	Type EventType
+	Job  Job
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// in order, and a function that ends the subscription. Events are buffered
+// without limit, so a slow subscriber never holds up the queue. The channel
+// is closed after the subscription ends or the queue is closed.
+func (q *Queue) Subscribe() (<-chan Event, func()) {
+	s := &subscriber{
+		wake: make(chan struct{}, 1),
+		out:  make(chan Event),
+		done: make(chan struct{}),
+	}
+	q.mu.Lock()
+	if q.closed {
+		s.closed = true
+	}
+	q.subs[s] = struct{}{}
+	q.mu.Unlock()
+	go s.pump()
+
+	var once sync.Once
+	return s.out, func() {
+		once.Do(func() {
+			q.mu.Lock()
+			delete(q.subs, s)
+			q.mu.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+// publishLocked sends an event about e, which may be nil, to every
+// subscriber.
+func (q *Queue) publishLocked(t EventType, e *entry) {
+	ev := Event{Type: t}
+	if e != nil {
+		ev.Job = e.Job
+	}
+	for s := range q.subs {
+		s.push(ev)
+	}
+}
+
+// subscriber buffers events between the queue and a consumer.
+type subscriber struct {
+	mu      sync.Mutex
+	pending []Event
+	closed  bool // no more events will be pushed
+
+	wake chan struct{} // signals new events or closing
+	out  chan Event
+	done chan struct{} // closed when the consumer unsubscribes
+}
+
+func (s *subscriber) push(ev Event) {
+	s.mu.Lock()
+	s.pending = append(s.pending, ev)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// close ends the subscription once the pending events are delivered.
+func (s *subscriber) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *subscriber) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pump delivers pending events to out until the subscription ends.
+func (s *subscriber) pump() {
+	defer close(s.out)
+	for {
+		s.mu.Lock()
+		events, closed := s.pending, s.closed
+		s.pending = nil
+		s.mu.Unlock()
+
+		for _, ev := range events {
+			select {
+			case s.out <- ev:
+			case <-s.done:
+				return
+			}
+		}
+		if closed && len(events) == 0 {
+			return
+		}
+		if len(events) > 0 {
+			continue
+		}
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
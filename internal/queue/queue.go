@@ -0,0 +1,381 @@
+// Package queue schedules chapter downloads. A Queue owns the jobs, starts
+// them by priority within global and per-series concurrency limits, lets
+// each job be paused, resumed, re-prioritised or cancelled while the queue
+// runs, and publishes every change as an Event to its subscribers.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mangadl/internal/domain"
+)
+
+// State is the lifecycle state of a job.
+type State int
+
+const (
+	Queued    State = iota // waiting for a free worker
+	Running                // being downloaded
+	Paused                 // held back until resumed
+	Done                   // downloaded
+	Failed                 // gave up after its attempts
+	Cancelled              // cancelled before finishing
+)
+
+var stateNames = []string{"queued", "running", "paused", "done", "failed", "cancelled"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// Finished reports whether the job has reached a final state.
+func (s State) Finished() bool { return s == Done || s == Failed || s == Cancelled }
+
+// Priorities for Add. Jobs with a higher priority start first; jobs with the
+// same priority start in the order they were added.
+const (
+	PriorityLow    = -10
+	PriorityNormal = 0
+	PriorityHigh   = 10
+)
+
+// ID identifies a job within its queue.
+type ID int
+
+// Job is a snapshot of one chapter download.
+type Job struct {
+	ID       ID
+	Series   *domain.MangaDetails
+	Chapter  domain.Chapter
+	Dir      string // series directory the chapter is saved under
+	Priority int
+	State    State
+	Attempts int                    // times the job has been started
+	Progress domain.ChapterProgress // of the running attempt
+	Result   *domain.ChapterResult  // of the last attempt, if any
+	Err      error                  // of the last attempt, if it failed
+	Added    time.Time
+	Started  time.Time // when the last attempt started
+}
+
+// seriesKey groups jobs for the per-series limit.
+func (j Job) seriesKey() string {
+	if j.Series != nil && j.Series.URL != "" {
+		return j.Series.URL
+	}
+	return j.Dir
+}
+
+// RunFunc downloads the chapter of job, passing updates to progress. It
+// must return promptly once ctx is cancelled, which happens when the job is
+// paused or cancelled.
+type RunFunc func(ctx context.Context, job Job, progress func(domain.ChapterProgress)) (*domain.ChapterResult, error)
+
+// Options are the scheduler limits.
+type Options struct {
+	Workers     int // jobs running at once; at least 1
+	PerSeries   int // jobs of one series running at once; 0 for no limit
+	MaxAttempts int // starts per job before it fails; 0 means 1
+}
+
+// Errors returned by the job controls.
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrFinished   = errors.New("job already finished")
+	ErrClosed     = errors.New("queue closed")
+)
+
+// entry is the queue's mutable record of a job.
+type entry struct {
+	Job
+	cancel context.CancelFunc // of the running attempt
+	want   State              // Paused, Queued or Cancelled once a stopped attempt returns
+}
+
+// Queue runs jobs. It is safe for concurrent use.
+type Queue struct {
+	run  RunFunc
+	opts Options
+
+	mu        sync.Mutex
+	ctx       context.Context
+	stop      context.CancelFunc
+	jobs      []*entry
+	byID      map[ID]*entry
+	nextID    ID
+	running   int
+	perSeries map[string]int
+	subs      map[*subscriber]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a queue that runs jobs with run.
+func New(run RunFunc, opts Options) *Queue {
+	opts.Workers = max(1, opts.Workers)
+	opts.MaxAttempts = max(1, opts.MaxAttempts)
+	ctx, stop := context.WithCancel(context.Background())
+	return &Queue{
+		run:       run,
+		opts:      opts,
+		ctx:       ctx,
+		stop:      stop,
+		byID:      make(map[ID]*entry),
+		perSeries: make(map[string]int),
+		subs:      make(map[*subscriber]struct{}),
+	}
+}
+
+// Add queues a chapter of series for download into dir and returns the job
+// ID. The job starts as soon as the limits allow.
+func (q *Queue) Add(series *domain.MangaDetails, chapter domain.Chapter, dir string, priority int) (ID, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrClosed
+	}
+	q.nextID++
+	e := &entry{Job: Job{
+		ID:       q.nextID,
+		Series:   series,
+		Chapter:  chapter,
+		Dir:      dir,
+		Priority: priority,
+		State:    Queued,
+		Added:    time.Now(),
+	}}
+	q.jobs = append(q.jobs, e)
+	q.byID[e.ID] = e
+	q.publishLocked(EventAdded, e)
+	q.scheduleLocked()
+	return e.ID, nil
+}
+
+// Jobs returns a snapshot of every job in the order they were added.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, len(q.jobs))
+	for i, e := range q.jobs {
+		jobs[i] = e.Job
+	}
+	return jobs
+}
+
+// Job returns a snapshot of the job with id.
+func (q *Queue) Job(id ID) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.byID[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.Job, true
+}
+
+// Pause holds a job back. A running job is stopped; the pages it saved are
+// kept, so resuming it continues where it left off.
+func (q *Queue) Pause(id ID) error {
+	return q.control(id, func(e *entry) {
+		switch e.State {
+		case Queued:
+			e.State = Paused
+			q.publishLocked(EventPaused, e)
+		case Running:
+			e.want = Paused
+			e.cancel()
+		}
+	})
+}
+
+// Resume queues a paused job again.
+func (q *Queue) Resume(id ID) error {
+	return q.control(id, func(e *entry) {
+		switch {
+		case e.State == Paused:
+			e.State = Queued
+			q.publishLocked(EventResumed, e)
+			q.scheduleLocked()
+		case e.State == Running && e.want == Paused:
+			e.want = Queued // the attempt is already stopping; requeue it
+		}
+	})
+}
+
+// Cancel stops a job for good.
+func (q *Queue) Cancel(id ID) error {
+	return q.control(id, func(e *entry) {
+		switch e.State {
+		case Queued, Paused:
+			e.State = Cancelled
+			q.publishLocked(EventCancelled, e)
+			q.idleLocked()
+		case Running:
+			e.want = Cancelled
+			e.cancel()
+		}
+	})
+}
+
+// SetPriority changes the priority of a job. It only affects jobs waiting
+// to start.
+func (q *Queue) SetPriority(id ID, priority int) error {
+	return q.control(id, func(e *entry) {
+		e.Priority = priority
+		q.publishLocked(EventUpdated, e)
+		q.scheduleLocked()
+	})
+}
+
+// control applies fn to the unfinished job with id under the lock.
+func (q *Queue) control(id ID, fn func(*entry)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.byID[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w %d", ErrUnknownJob, id)
+	case e.State.Finished():
+		return fmt.Errorf("job %d: %w", id, ErrFinished)
+	}
+	fn(e)
+	return nil
+}
+
+// PauseAll pauses every unfinished job.
+func (q *Queue) PauseAll() { q.each(q.Pause) }
+
+// ResumeAll resumes every paused job.
+func (q *Queue) ResumeAll() { q.each(q.Resume) }
+
+// CancelAll cancels every unfinished job.
+func (q *Queue) CancelAll() { q.each(q.Cancel) }
+
+func (q *Queue) each(fn func(ID) error) {
+	for _, j := range q.Jobs() {
+		if !j.State.Finished() {
+			fn(j.ID)
+		}
+	}
+}
+
+// Close cancels every job, waits for running ones to stop and ends all
+// subscriptions once their pending events are delivered.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	q.CancelAll()
+	q.stop()
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for s := range q.subs {
+		s.close()
+	}
+}
+
+// scheduleLocked starts queued jobs, highest priority first, while workers
+// are free.
+func (q *Queue) scheduleLocked() {
+	for q.running < q.opts.Workers && !q.closed {
+		var next *entry
+		for _, e := range q.jobs {
+			if e.State != Queued {
+				continue
+			}
+			if q.opts.PerSeries > 0 && q.perSeries[e.seriesKey()] >= q.opts.PerSeries {
+				continue
+			}
+			if next == nil || e.Priority > next.Priority {
+				next = e
+			}
+		}
+		if next == nil {
+			return
+		}
+		q.startLocked(next)
+	}
+}
+
+func (q *Queue) startLocked(e *entry) {
+	ctx, cancel := context.WithCancel(q.ctx)
+	e.State, e.want, e.cancel = Running, Running, cancel
+	e.Attempts++
+	e.Started = time.Now()
+	e.Progress = domain.ChapterProgress{}
+	q.running++
+	q.perSeries[e.seriesKey()]++
+	q.publishLocked(EventStarted, e)
+
+	job := e.Job
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		result, err := q.run(ctx, job, func(p domain.ChapterProgress) {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			e.Progress = p
+			q.publishLocked(EventProgress, e)
+		})
+		cancel()
+		q.finish(e, result, err)
+	}()
+}
+
+// finish records the outcome of an attempt and schedules the next jobs.
+func (q *Queue) finish(e *entry, result *domain.ChapterResult, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running--
+	q.perSeries[e.seriesKey()]--
+	e.cancel = nil
+	e.Result, e.Err = result, err
+
+	switch {
+	case err == nil:
+		e.State = Done
+		q.publishLocked(EventDone, e)
+	case e.want == Paused:
+		e.State = Paused
+		q.publishLocked(EventPaused, e)
+	case e.want == Queued:
+		e.State = Queued
+		q.publishLocked(EventResumed, e)
+	case e.want == Cancelled || q.closed || errors.Is(err, context.Canceled):
+		e.State = Cancelled
+		q.publishLocked(EventCancelled, e)
+	case e.Attempts < q.opts.MaxAttempts:
+		e.State = Queued
+		q.publishLocked(EventRetrying, e)
+	default:
+		e.State = Failed
+		q.publishLocked(EventFailed, e)
+	}
+	q.scheduleLocked()
+	q.idleLocked()
+}
+
+// idleLocked publishes EventIdle when no job is queued or running.
+func (q *Queue) idleLocked() {
+	for _, e := range q.jobs {
+		if e.State == Queued || e.State == Running {
+			return
+		}
+	}
+	q.publishLocked(EventIdle, nil)
+}
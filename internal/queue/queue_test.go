@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"mangadl/internal/domain"
+)
+
+// blockingRun runs jobs until their chapter name is released or their
+// context is cancelled, recording the order they started in.
+type blockingRun struct {
+	mu      sync.Mutex
+	started []string
+	release map[string]chan error
+}
+
+func newBlockingRun() *blockingRun {
+	return &blockingRun{release: make(map[string]chan error)}
+}
+
+func (b *blockingRun) ch(name string) chan error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.release[name] == nil {
+		b.release[name] = make(chan error, 1)
+	}
+	return b.release[name]
+}
+
+func (b *blockingRun) run(ctx context.Context, job Job, progress func(domain.ChapterProgress)) (*domain.ChapterResult, error) {
+	progress(domain.ChapterProgress{PagesTotal: 1})
+	b.mu.Lock()
+	b.started = append(b.started, job.Chapter.Name)
+	b.mu.Unlock()
+	select {
+	case err := <-b.ch(job.Chapter.Name):
+		return &domain.ChapterResult{Chapter: job.Chapter}, err
+	case <-ctx.Done():
+		return &domain.ChapterResult{Chapter: job.Chapter}, ctx.Err()
+	}
+}
+
+func (b *blockingRun) startedOrder() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.started)
+}
+
+// waitFor reads events until one of type t for the chapter named name
+// arrives (any job if name is empty).
+func waitFor(t *testing.T, events <-chan Event, typ EventType, name string) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed while waiting for %s %s", typ, name)
+			}
+			if ev.Type == typ && (name == "" || ev.Job.Chapter.Name == name) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s %s", typ, name)
+		}
+	}
+}
+
+var series = &domain.MangaDetails{Title: "A", URL: "https://example.com/a"}
+
+func chapter(name string) domain.Chapter { return domain.Chapter{Name: name, ID: name} }
+
+func TestPriorityOrder(t *testing.T) {
+	b := newBlockingRun()
+	q := New(b.run, Options{Workers: 1})
+	defer q.Close()
+	events, unsubscribe := q.Subscribe()
+	defer unsubscribe()
+
+	q.Add(series, chapter("first"), "dir", PriorityNormal)
+	waitFor(t, events, EventStarted, "first")
+	q.Add(series, chapter("low"), "dir", PriorityLow)
+	q.Add(series, chapter("normal"), "dir", PriorityNormal)
+	high, _ := q.Add(series, chapter("high"), "dir", PriorityNormal)
+	q.SetPriority(high, PriorityHigh)
+
+	for _, name := range []string{"first", "high", "normal", "low"} {
+		b.ch(name) <- nil
+		waitFor(t, events, EventDone, name)
+	}
+	waitFor(t, events, EventIdle, "")
+	if got, want := b.startedOrder(), []string{"first", "high", "normal", "low"}; !slices.Equal(got, want) {
+		t.Errorf("started %v; want %v", got, want)
+	}
+}
+
+func TestPerSeriesLimit(t *testing.T) {
+	b := newBlockingRun()
+	q := New(b.run, Options{Workers: 3, PerSeries: 1})
+	defer q.Close()
+	events, unsubscribe := q.Subscribe()
+	defer unsubscribe()
+
+	other := &domain.MangaDetails{Title: "B", URL: "https://example.com/b"}
+	q.Add(series, chapter("a1"), "a", PriorityNormal)
+	q.Add(series, chapter("a2"), "a", PriorityNormal)
+	q.Add(other, chapter("b1"), "b", PriorityNormal)
+	waitFor(t, events, EventStarted, "a1")
+	waitFor(t, events, EventStarted, "b1")
+
+	if j, _ := q.Job(2); j.State != Queued {
+		t.Errorf("a2 is %s while a1 runs; want queued", j.State)
+	}
+	b.ch("a1") <- nil
+	waitFor(t, events, EventStarted, "a2")
+}
+
+func TestPauseResumeCancel(t *testing.T) {
+	b := newBlockingRun()
+	q := New(b.run, Options{Workers: 1})
+	defer q.Close()
+	events, unsubscribe := q.Subscribe()
+	defer unsubscribe()
+
+	running, _ := q.Add(series, chapter("running"), "dir", PriorityNormal)
+	waiting, _ := q.Add(series, chapter("waiting"), "dir", PriorityNormal)
+	waitFor(t, events, EventStarted, "running")
+	if ev := waitFor(t, events, EventProgress, "running"); ev.Job.Progress.PagesTotal != 1 {
+		t.Errorf("progress = %+v", ev.Job.Progress)
+	}
+
+	// Pausing the running job frees its worker for the next one.
+	if err := q.Pause(running); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, events, EventPaused, "running")
+	waitFor(t, events, EventStarted, "waiting")
+
+	if err := q.Resume(running); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel(waiting); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, events, EventCancelled, "waiting")
+	ev := waitFor(t, events, EventStarted, "running")
+	if ev.Job.Attempts != 2 {
+		t.Errorf("attempts = %d; want 2", ev.Job.Attempts)
+	}
+	b.ch("running") <- nil
+	waitFor(t, events, EventDone, "running")
+	waitFor(t, events, EventIdle, "")
+
+	if err := q.Cancel(running); !errors.Is(err, ErrFinished) {
+		t.Errorf("Cancel of a finished job = %v; want ErrFinished", err)
+	}
+	if err := q.Pause(99); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Pause of an unknown job = %v; want ErrUnknownJob", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	b := newBlockingRun()
+	q := New(b.run, Options{Workers: 1, MaxAttempts: 2})
+	defer q.Close()
+	events, unsubscribe := q.Subscribe()
+	defer unsubscribe()
+
+	id, _ := q.Add(series, chapter("flaky"), "dir", PriorityNormal)
+	b.ch("flaky") <- errors.New("boom")
+	waitFor(t, events, EventRetrying, "flaky")
+	b.ch("flaky") <- errors.New("boom again")
+	ev := waitFor(t, events, EventFailed, "flaky")
+	if ev.Job.Attempts != 2 || ev.Job.Err == nil || ev.Job.Result == nil {
+		t.Errorf("failed job = %+v", ev.Job)
+	}
+	if j, _ := q.Job(id); j.State != Failed {
+		t.Errorf("state = %s; want failed", j.State)
+	}
+}
+
+func TestCloseDrainsEvents(t *testing.T) {
+	b := newBlockingRun()
+	q := New(b.run, Options{Workers: 1})
+	events, _ := q.Subscribe()
+
+	q.Add(series, chapter("a"), "dir", PriorityNormal)
+	q.Add(series, chapter("b"), "dir", PriorityNormal)
+	q.Close()
+
+	var cancelled int
+	for ev := range events {
+		if ev.Type == EventCancelled {
+			cancelled++
+		}
+	}
+	if cancelled != 2 {
+		t.Errorf("cancelled events = %d; want 2", cancelled)
+	}
+	if _, err := q.Add(series, chapter("c"), "dir", PriorityNormal); !errors.Is(err, ErrClosed) {
+		t.Errorf("Add after Close = %v; want ErrClosed", err)
+	}
+}
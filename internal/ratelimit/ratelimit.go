@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limit is a token-bucket rate: RPS requests per second on average, with
+// bursts of up to Burst requests.
+type Limit struct {
+	RPS   float64
+	Burst int
+}
+
+const (
+	// minRPS is the floor automatic slow-down never goes below.
+	minRPS = 0.1
+	// recoveryWindow is how long a host must go without a 429 before its
+	// rate is doubled back towards the configured limit.
+	recoveryWindow = 30 * time.Second
+)
+
+// Limiter hands out request slots per host. It is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+type bucket struct {
+	limit     Limit   // configured rate
+	rps       float64 // current rate, lowered after 429s
+	tokens    float64
+	last      time.Time
+	recoverAt time.Time
+}
+
+// New returns an empty limiter.
+func New() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+		sleep:   sleep,
+	}
+}
+
+// sleep waits for d or until ctx is done, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until a request to host may be sent. lim configures the host
+// the first time it is seen; later calls with a different limit update it.
+// A zero or negative RPS disables limiting for the host. If ctx is done
+// first, Wait returns its error; the slot stays taken.
+func (l *Limiter) Wait(ctx context.Context, host string, lim Limit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if lim.RPS <= 0 {
+		return nil
+	}
+	if d := l.reserve(host, lim); d > 0 {
+		return l.sleep(ctx, d)
+	}
+	return nil
+}
+
+// reserve takes a token for host and returns how long the caller must wait
+// before using it.
+func (l *Limiter) reserve(host string, lim Limit) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	burst := float64(max(lim.Burst, 1))
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &bucket{limit: lim, rps: lim.RPS, tokens: burst, last: now}
+		l.buckets[host] = b
+	} else if b.limit != lim {
+		if b.rps >= b.limit.RPS {
+			b.rps = lim.RPS // not slowed down, follow the new limit
+		} else {
+			b.rps = min(b.rps, lim.RPS)
+		}
+		b.limit = lim
+		b.tokens = min(b.tokens, burst)
+	}
+
+	// Recover from an earlier slow-down one step at a time.
+	if b.rps < b.limit.RPS && !b.recoverAt.IsZero() && now.After(b.recoverAt) {
+		b.rps = min(b.rps*2, b.limit.RPS)
+		b.recoverAt = now.Add(recoveryWindow)
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*b.rps, burst)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	// The deficit is paid back at the current rate; tokens stays negative so
+	// concurrent callers queue up behind each other.
+	return time.Duration(-b.tokens / b.rps * float64(time.Second))
+}
+
+// Penalize halves the rate for host after it answered 429 Too Many Requests.
+// The rate recovers gradually once the host stops complaining.
+func (l *Limiter) Penalize(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[host]
+	if !ok {
+		return
+	}
+	b.rps = max(b.rps/2, minRPS)
+	b.tokens = min(b.tokens, 0)
+	b.recoverAt = l.now().Add(recoveryWindow)
+}
+
+// Rate returns the current rate for host, or 0 if it has not been seen.
+func (l *Limiter) Rate(host string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[host]; ok {
+		return b.rps
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock advances only when the limiter sleeps.
+type fakeClock struct{ t time.Time }
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New()
+	l.now = func() time.Time { return clock.t }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		clock.t = clock.t.Add(d)
+		return nil
+	}
+	return l, clock
+}
+
+func TestWaitBurstThenRate(t *testing.T) {
+	l, clock := newTestLimiter()
+	start := clock.t
+	lim := Limit{RPS: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		l.Wait(context.Background(), "a.example", lim)
+	}
+	if clock.t != start {
+		t.Fatalf("burst should not wait, waited %v", clock.t.Sub(start))
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Wait(context.Background(), "a.example", lim)
+	}
+	if got := clock.t.Sub(start); got != 2*time.Second {
+		t.Errorf("4 requests at 2 rps took %v; want 2s", got)
+	}
+
+	// Other hosts have their own bucket.
+	before := clock.t
+	l.Wait(context.Background(), "b.example", lim)
+	if clock.t != before {
+		t.Errorf("unrelated host should not wait")
+	}
+}
+
+func TestWaitDisabled(t *testing.T) {
+	l, clock := newTestLimiter()
+	start := clock.t
+	for i := 0; i < 100; i++ {
+		l.Wait(context.Background(), "a.example", Limit{})
+	}
+	if clock.t != start {
+		t.Errorf("zero limit should never wait")
+	}
+}
+
+func TestPenalizeAndRecover(t *testing.T) {
+	l, clock := newTestLimiter()
+	lim := Limit{RPS: 8, Burst: 1}
+	l.Wait(context.Background(), "a.example", lim)
+
+	l.Penalize("a.example")
+	l.Penalize("a.example")
+	if got := l.Rate("a.example"); got != 2 {
+		t.Fatalf("rate after two 429s = %v; want 2", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		l.Penalize("a.example")
+	}
+	if got := l.Rate("a.example"); got != minRPS {
+		t.Fatalf("rate should floor at %v, got %v", minRPS, got)
+	}
+
+	// Each quiet recovery window doubles the rate until the limit is reached.
+	for i := 0; i < 10; i++ {
+		clock.t = clock.t.Add(recoveryWindow + time.Second)
+		l.Wait(context.Background(), "a.example", lim)
+	}
+	if got := l.Rate("a.example"); got != lim.RPS {
+		t.Errorf("rate after recovery = %v; want %v", got, lim.RPS)
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	l := New()
+	lim := Limit{RPS: 0.01, Burst: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.Wait(ctx, "a.example", lim); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- l.Wait(ctx, "a.example", lim) }()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Wait = %v; want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after cancel")
+	}
+}
@@ -0,0 +1,158 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mangadl/internal/config"
+)
+
+// Policy describes how often and how patiently a request is retried.
+type Policy struct {
+	Attempts  int           // total attempts, including the first one
+	BaseDelay time.Duration // delay before the first retry
+	MaxDelay  time.Duration // cap for backoff and Retry-After; 0 for none
+	Jitter    float64       // fraction of each delay that is randomised, 0..1
+}
+
+// NewPolicy builds a policy from the user configuration.
+func NewPolicy(cfg config.RetryConfig) Policy {
+	return Policy{
+		Attempts:  cfg.Attempts,
+		BaseDelay: cfg.BaseDelay,
+		MaxDelay:  cfg.MaxDelay,
+		Jitter:    0.5,
+	}
+}
+
+// sleep waits for d or until ctx is done. It is replaced in tests.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StatusError reports an unexpected HTTP status. RetryAfter is set when the
+// server sent a Retry-After header.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Retryable reports whether err is worth another attempt. HTTP statuses
+// are retried only for timeouts, rate limiting and server errors; other
+// errors (network failures, timeouts) are retried unless marked Permanent
+// or caused by cancellation.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		switch status.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error, or the
+// attempts are used up. It stops early when ctx is done, returning the
+// context's error. It returns the number of retries made and the last
+// error.
+func (p Policy) Do(ctx context.Context, fn func() error) (int, error) {
+	attempts := max(p.Attempts, 1)
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if serr := sleep(ctx, p.Delay(attempt, err)); serr != nil {
+				return attempt - 1, serr
+			}
+		}
+		if err = fn(); err == nil || !Retryable(err) {
+			return attempt, err
+		}
+		if ctx.Err() != nil {
+			return attempt, ctx.Err()
+		}
+	}
+	return attempts - 1, err
+}
+
+// Delay returns how long to wait before retry number attempt (1-based),
+// honouring a Retry-After carried by err.
+func (p Policy) Delay(attempt int, err error) time.Duration {
+	var status *StatusError
+	if errors.As(err, &status) && status.RetryAfter > 0 {
+		if p.MaxDelay > 0 {
+			return min(status.RetryAfter, p.MaxDelay)
+		}
+		return status.RetryAfter
+	}
+
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 {
+		delay = math.Min(delay, float64(p.MaxDelay))
+	}
+	if p.Jitter > 0 {
+		jitter := delay * math.Min(p.Jitter, 1)
+		delay = delay - jitter + rand.Float64()*jitter
+	}
+	return time.Duration(delay)
+}
+
+// ParseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date. It returns 0 when the header is absent or invalid.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
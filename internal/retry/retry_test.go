@@ -0,0 +1,132 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var slept []time.Duration
+	defer func(orig func(context.Context, time.Duration) error) { sleep = orig }(sleep)
+	sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	p := Policy{Attempts: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		name        string
+		errs        []error
+		wantRetries int
+		wantErr     bool
+	}{
+		{"success", []error{nil}, 0, false},
+		{"retry then success", []error{errors.New("timeout"), &StatusError{StatusCode: 503}, nil}, 2, false},
+		{"permanent status", []error{&StatusError{StatusCode: 404}}, 0, true},
+		{"permanent wrapper", []error{Permanent(errors.New("bad"))}, 0, true},
+		{"exhausted", []error{&StatusError{StatusCode: 500}, &StatusError{StatusCode: 500},
+			&StatusError{StatusCode: 500}, &StatusError{StatusCode: 500}}, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slept = nil
+			calls := 0
+			retries, err := p.Do(context.Background(), func() error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			if retries != tt.wantRetries {
+				t.Errorf("retries = %d; want %d", retries, tt.wantRetries)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if calls != len(tt.errs) {
+				t.Errorf("calls = %d; want %d", calls, len(tt.errs))
+			}
+			if len(slept) != tt.wantRetries {
+				t.Errorf("slept %d times; want %d", len(slept), tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{Attempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	calls := 0
+	_, err := p.Do(ctx, func() error {
+		calls++
+		cancel()
+		return &StatusError{StatusCode: 503}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v; want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d; want 1", calls)
+	}
+	if Retryable(fmt.Errorf("get: %w", context.Canceled)) {
+		t.Error("cancellation should not be retryable")
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		6: time.Second, // capped
+	} {
+		if got := p.Delay(attempt, nil); got != want {
+			t.Errorf("Delay(%d) = %v; want %v", attempt, got, want)
+		}
+	}
+
+	retryAfter := fmt.Errorf("fetch: %w", &StatusError{StatusCode: 429, RetryAfter: 700 * time.Millisecond})
+	if got := p.Delay(1, retryAfter); got != 700*time.Millisecond {
+		t.Errorf("Retry-After not honoured: %v", got)
+	}
+	longRetryAfter := &StatusError{StatusCode: 503, RetryAfter: time.Hour}
+	if got := p.Delay(1, longRetryAfter); got != time.Second {
+		t.Errorf("Retry-After not capped: %v", got)
+	}
+	if got := (Policy{BaseDelay: p.BaseDelay}).Delay(1, longRetryAfter); got != time.Hour {
+		t.Errorf("Retry-After without MaxDelay = %v; want %v", got, time.Hour)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.Delay(2, nil); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("jittered delay %v out of range", got)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v; want %v", tt.value, got, tt.want)
+		}
+	}
+}
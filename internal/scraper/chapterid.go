@@ -0,0 +1,67 @@
+package scraper
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"mangadl/internal/domain"
+)
+
+// ChapterKeyer is implemented by sources whose chapter URLs carry a stable
+// chapter identifier, so chapters keep their identity when the rest of the
+// URL changes, for example when the site moves to another domain. Sources
+// without it are keyed by the URL path and query.
+type ChapterKeyer interface {
+	ChapterKey(u *url.URL) string
+}
+
+// ChapterKey returns the key src derives from a chapter URL, or "" if the
+// URL cannot be parsed. src may be nil.
+func ChapterKey(src Source, rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	if k, ok := src.(ChapterKeyer); ok {
+		if key := k.ChapterKey(u); key != "" {
+			return key
+		}
+	}
+	key := strings.Trim(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
+
+// AssignChapterIDs sets the Index, Number and ID of chapters, which must be
+// in the order the source lists them. The ID is the chapter key of the URL,
+// so it survives the list being re-ordered or extended. Chapters that share
+// a key are told apart by their number and, failing that, their index, with
+// the first one listed keeping the bare key.
+func AssignChapterIDs(src Source, chapters []domain.Chapter) {
+	taken := make(map[string]bool, len(chapters))
+	for i := range chapters {
+		c := &chapters[i]
+		c.Index = i
+		c.Number = domain.ParseChapterNumber(c.Name)
+
+		key := ChapterKey(src, c.URL)
+		candidates := []string{
+			key,
+			key + "#ch" + strconv.FormatFloat(c.Number.Float(), 'f', -1, 64),
+			key + "#idx" + strconv.Itoa(i),
+		}
+		if key == "" {
+			candidates = candidates[1:]
+		}
+		for _, id := range candidates {
+			if !taken[id] {
+				c.ID = id
+				break
+			}
+		}
+		taken[c.ID] = true
+	}
+}
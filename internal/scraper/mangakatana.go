@@ -0,0 +1,171 @@
+package scraper
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"mangadl/internal/domain"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const mangaKatanaBase = "https://mangakatana.com"
+
+var (
+	mangaKatanaChapterRegex = regexp.MustCompile(`/manga/.*/c\d+`)
+	mangaKatanaKeyRegex     = regexp.MustCompile(`/(c\d+(?:[.-]\d+)?)/?$`)
+	scriptImageRegex        = regexp.MustCompile(`https?://[^"']+\.(?:jpg|png|jpeg|webp)`)
+)
+
+func init() {
+	Register(MangaKatana{})
+}
+
+// MangaKatana scrapes https://mangakatana.com.
+type MangaKatana struct{}
+
+// Name returns the site name.
+func (MangaKatana) Name() string { return "MangaKatana" }
+
+// Match claims mangakatana.com and its subdomains.
+func (MangaKatana) Match(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	return host == "mangakatana.com" || strings.HasSuffix(host, ".mangakatana.com")
+}
+
+// Headers returns the Referer the image CDN checks for.
+func (MangaKatana) Headers() map[string]string {
+	return map[string]string{"Referer": mangaKatanaBase + "/"}
+}
+
+// ChapterKey returns the chapter segment of a chapter URL, such as "c1000"
+// for /manga/one-piece.20/c1000, which stays the same when the series slug
+// or the domain changes.
+func (MangaKatana) ChapterKey(u *url.URL) string {
+	if m := mangaKatanaKeyRegex.FindStringSubmatch(u.Path); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Series extracts the title, cover and metadata from a series page.
+func (MangaKatana) Series(doc *goquery.Document) (*domain.MangaDetails, error) {
+	title := doc.Find("h1.heading").Text()
+	if title == "" {
+		title = doc.Find("title").Text()
+	}
+	details := &domain.MangaDetails{
+		Title:    strings.TrimSpace(title),
+		Summary:  strings.TrimSpace(doc.Find(".summary p").First().Text()),
+		Authors:  selectionTexts(doc.Find("a.author")),
+		Genres:   selectionTexts(doc.Find(".genres a")),
+		Language: "en",
+	}
+	if cover, ok := doc.Find("div.cover img").Attr("src"); ok {
+		details.CoverURL = resolveURL(doc, mangaKatanaBase, cover)
+	}
+	return details, nil
+}
+
+// selectionTexts returns the trimmed, de-duplicated text of each element.
+func selectionTexts(sel *goquery.Selection) []string {
+	var texts []string
+	seen := make(map[string]bool)
+	sel.Each(func(i int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		if text != "" && !seen[text] {
+			texts = append(texts, text)
+			seen[text] = true
+		}
+	})
+	return texts
+}
+
+// Chapters extracts the chapter links from a series page.
+func (MangaKatana) Chapters(doc *goquery.Document) []domain.Chapter {
+	chapters := collectChapters(doc, doc.Find(".chapters a[href]"))
+	// If no chapters found with specific selector, try generic (fallback)
+	if len(chapters) == 0 {
+		chapters = collectChapters(doc, doc.Find("a[href]"))
+	}
+	return chapters
+}
+
+func collectChapters(doc *goquery.Document, links *goquery.Selection) []domain.Chapter {
+	chapters := []domain.Chapter{}
+	seenChapters := make(map[string]bool)
+
+	links.Each(func(i int, s *goquery.Selection) {
+		href, exists := s.Attr("href")
+		if !exists || !mangaKatanaChapterRegex.MatchString(href) {
+			return
+		}
+		chapterURL := resolveURL(doc, mangaKatanaBase, href)
+		if chapterURL == "" || seenChapters[chapterURL] {
+			return
+		}
+		chapterName := strings.TrimSpace(s.Text())
+		if chapterName != "" {
+			chapters = append(chapters, domain.Chapter{Name: chapterName, URL: chapterURL})
+			seenChapters[chapterURL] = true
+		}
+	})
+	return chapters
+}
+
+// Pages extracts the page image URLs from a chapter page.
+func (MangaKatana) Pages(doc *goquery.Document) []string {
+	return ExtractImageURLs(doc)
+}
+
+// ExtractImageURLs finds all image URLs on a MangaKatana chapter page.
+func ExtractImageURLs(doc *goquery.Document) []string {
+	imageURLs := []string{}
+	seen := make(map[string]bool)
+
+	// Strategy 1: Find direct image tags
+	doc.Find("div#imgs img").Each(func(i int, s *goquery.Selection) {
+		if url := getImageURL(doc, s); url != "" && !seen[url] {
+			imageURLs = append(imageURLs, url)
+			seen[url] = true
+		}
+	})
+
+	// Strategy 2: Find embedded image data in scripts
+	if len(imageURLs) == 0 {
+		doc.Find("script").Each(func(i int, s *goquery.Selection) {
+			content := s.Text()
+			if strings.Contains(content, "ytaw") || strings.Contains(content, "images") {
+				for _, url := range scriptImageRegex.FindAllString(content, -1) {
+					if !seen[url] {
+						imageURLs = append(imageURLs, url)
+						seen[url] = true
+					}
+				}
+			}
+		})
+	}
+	return imageURLs
+}
+
+// getImageURL extracts the best available source URL from an image element.
+func getImageURL(doc *goquery.Document, s *goquery.Selection) string {
+	url, _ := s.Attr("data-src")
+	if url == "" {
+		url, _ = s.Attr("src")
+	}
+	if url == "" {
+		url, _ = s.Attr("data-lazy-src")
+	}
+	if url == "" || url == "#" || strings.HasPrefix(url, "data:image") {
+		return ""
+	}
+	url = resolveURL(doc, mangaKatanaBase, url)
+	lower := strings.ToLower(url)
+	if !strings.Contains(lower, ".jpg") && !strings.Contains(lower, ".jpeg") &&
+		!strings.Contains(lower, ".png") && !strings.Contains(lower, ".webp") {
+		return ""
+	}
+	return url
+}
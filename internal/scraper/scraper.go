@@ -1,193 +1,197 @@
 package scraper
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"regexp"
-	"sort"
+	"slices"
 	"strconv"
-	"strings"
 	"time"
 
 	"mangadl/internal/config"
 	"mangadl/internal/domain"
+	"mangadl/internal/ratelimit"
+	"mangadl/internal/retry"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
-var (
-	httpClient *http.Client
-)
+// Scraper fetches series and chapter pages from the registered sources.
+// It is safe for concurrent use.
+type Scraper struct {
+	cfg     config.Config
+	client  *http.Client
+	limiter *ratelimit.Limiter
+	retry   retry.Policy
+}
 
-func init() {
+// New returns a scraper using cfg for timeouts, retries and rate limits.
+func New(cfg config.Config) *Scraper {
 	transport := &http.Transport{
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 10,
 		IdleConnTimeout:     90 * time.Second,
 	}
-	httpClient = &http.Client{Transport: transport, Timeout: config.DefaultHTTPTimeout}
+	return &Scraper{
+		cfg:     cfg,
+		client:  &http.Client{Transport: transport, Timeout: cfg.HTTPTimeout},
+		limiter: ratelimit.New(),
+		retry:   retry.NewPolicy(cfg.Retry),
+	}
 }
 
-// FetchMangaDetails fetches the title and chapters for a manga URL.
-func FetchMangaDetails(mangaURL string) (*domain.MangaDetails, error) {
-	doc, err := fetchPage(mangaURL)
+// Limiter returns the per-host rate limiter, so the downloader can share it.
+func (s *Scraper) Limiter() *ratelimit.Limiter { return s.limiter }
+
+// FetchMangaDetails fetches the title and chapters for a manga URL using
+// whichever registered source claims it. Chapters are sorted by number and
+// carry the IDs assigned by AssignChapterIDs.
+func (s *Scraper) FetchMangaDetails(ctx context.Context, mangaURL string) (*domain.MangaDetails, error) {
+	src, err := Lookup(mangaURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch page: %w", err)
+		return nil, err
 	}
 
-	title := doc.Find("h1.heading").Text()
-	if title == "" {
-		title = doc.Find("title").Text()
+	doc, err := s.fetchPage(ctx, mangaURL, s.optionsFor(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page: %w", err)
 	}
 
-	chapters := []domain.Chapter{}
-	seenChapters := make(map[string]bool)
-	chapterRegex := regexp.MustCompile(`/manga/.*/c\d+`)
-
-	doc.Find(".chapters a[href]").Each(func(i int, s *goquery.Selection) {
-		href, exists := s.Attr("href")
-		if !exists {
-			return
+	details, err := src.Series(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse series: %w", err)
+	}
+	details.URL = mangaURL
+	details.Source = src.Name()
+	details.Chapters = src.Chapters(doc)
+	AssignChapterIDs(src, details.Chapters)
+	for i := range details.Chapters {
+		c := &details.Chapters[i]
+		if c.Volume == "" {
+			c.Volume = ParseVolume(c.Name)
 		}
-
-		// If generic link regex match fails, skip
-		if !chapterRegex.MatchString(href) {
-			return
+		if c.Number.Volume == 0 {
+			c.Number.Volume, _ = strconv.Atoi(c.Volume)
 		}
+	}
 
-		chapterURL := href
-		if !strings.HasPrefix(href, "http") {
-			chapterURL = "https://mangakatana.com" + href
-		}
-		if seenChapters[chapterURL] {
-			return
-		}
-		chapterName := strings.TrimSpace(s.Text())
-		if chapterName != "" {
-			chapters = append(chapters, domain.Chapter{Name: chapterName, URL: chapterURL})
-			seenChapters[chapterURL] = true
-		}
+	// Sort chapters into reading order; equal numbers keep the listing order.
+	slices.SortStableFunc(details.Chapters, func(a, b domain.Chapter) int {
+		return a.Number.Compare(b.Number)
 	})
 
-	// If no chapters found with specific selector, try generic (fallback)
-	if len(chapters) == 0 {
-		doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
-			href, exists := s.Attr("href")
-			if !exists || !chapterRegex.MatchString(href) {
-				return
-			}
-			chapterURL := href
-			if !strings.HasPrefix(href, "http") {
-				chapterURL = "https://mangakatana.com" + href
-			}
-			if seenChapters[chapterURL] {
-				return
-			}
-			chapterName := strings.TrimSpace(s.Text())
-			if chapterName != "" {
-				chapters = append(chapters, domain.Chapter{Name: chapterName, URL: chapterURL})
-				seenChapters[chapterURL] = true
-			}
-		})
-	}
-
-	// Sort chapters by number (ascending)
-	sort.Slice(chapters, func(i, j int) bool {
-		return parseChapterNumber(chapters[i].Name) < parseChapterNumber(chapters[j].Name)
-	})
+	return details, nil
+}
 
-	return &domain.MangaDetails{Title: strings.TrimSpace(title), Chapters: chapters}, nil
+// FetchPageURLs fetches a chapter page and returns its image URLs.
+func (s *Scraper) FetchPageURLs(ctx context.Context, chapterURL string) ([]string, error) {
+	src, err := Lookup(chapterURL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := s.fetchPage(ctx, chapterURL, s.optionsFor(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page: %w", err)
+	}
+	return src.Pages(doc), nil
 }
 
-func parseChapterNumber(name string) float64 {
-	// Extract number from "Chapter 10.5" or similar
-	re := regexp.MustCompile(`(?i)(?:chapter|ch\.?)\s*(\d+(?:\.\d+)?)`)
-	matches := re.FindStringSubmatch(name)
-	if len(matches) > 1 {
-		val, err := strconv.ParseFloat(matches[1], 64)
-		if err == nil {
-			return val
-		}
+// RequestOptions are the per-source settings applied to every request made
+// on behalf of that source, including its image CDN.
+type RequestOptions struct {
+	Headers map[string]string
+	Limit   ratelimit.Limit
+}
+
+// Options returns the request options of the source owning rawURL. Unknown
+// URLs get no extra headers and the default rate limit.
+func (s *Scraper) Options(rawURL string) RequestOptions {
+	src, err := Lookup(rawURL)
+	if err != nil {
+		return s.optionsFor(nil)
 	}
-	// Fallback: try to find any float in the string
-	re = regexp.MustCompile(`(\d+(?:\.\d+)?)`)
-	matches = re.FindStringSubmatch(name)
-	if len(matches) > 1 {
-		val, err := strconv.ParseFloat(matches[1], 64)
-		if err == nil {
-			return val
+	return s.optionsFor(src)
+}
+
+// optionsFor resolves the options for src, which may be nil. The rate limit
+// comes from the config's per-source entry, then the source itself, then
+// the config default.
+func (s *Scraper) optionsFor(src Source) RequestOptions {
+	opts := RequestOptions{Limit: SourceLimit(s.cfg, src)}
+	if src != nil {
+		opts.Headers = src.Headers()
+	}
+	return opts
+}
+
+// SourceLimit returns the rate limit of src, which may be nil, under cfg:
+// its entry in cfg.Sources, then its own RateLimit, then cfg.RateLimit.
+func SourceLimit(cfg config.Config, src Source) ratelimit.Limit {
+	if src != nil {
+		if lim, ok := cfg.Sources[src.Name()]; ok {
+			return ratelimit.Limit{RPS: lim.RPS, Burst: lim.Burst}
+		}
+		if rl, ok := src.(RateLimited); ok {
+			return rl.RateLimit()
 		}
 	}
-	return 0
+	return ratelimit.Limit{RPS: cfg.RateLimit.RPS, Burst: cfg.RateLimit.Burst}
 }
 
-// ExtractImageURLs finds all image URLs on a chapter page.
-func ExtractImageURLs(doc *goquery.Document) []string {
-	imageURLs := []string{}
-	seen := make(map[string]bool)
+var volumeRegex = regexp.MustCompile(`(?i)\bvol(?:ume)?\.?\s*(\d+(?:\.\d+)?)`)
 
-	// Strategy 1: Find direct image tags
-	doc.Find("div#imgs img").Each(func(i int, s *goquery.Selection) {
-		if url := getImageURL(s); url != "" && !seen[url] {
-			imageURLs = append(imageURLs, url)
-			seen[url] = true
-		}
-	})
+// ParseVolume extracts the volume from a chapter name such as
+// "Vol.3 Chapter 20", or returns "" when the name has none.
+func ParseVolume(name string) string {
+	if m := volumeRegex.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return ""
+}
 
-	// Strategy 2: Find embedded image data in scripts
-	if len(imageURLs) == 0 {
-		doc.Find("script").Each(func(i int, s *goquery.Selection) {
-			content := s.Text()
-			if strings.Contains(content, "ytaw") || strings.Contains(content, "images") {
-				regex := regexp.MustCompile(`https?://[^"']+\.(?:jpg|png|jpeg|webp)`)
-				matches := regex.FindAllString(content, -1)
-				for _, url := range matches {
-					if !seen[url] {
-						imageURLs = append(imageURLs, url)
-						seen[url] = true
-					}
-				}
-			}
-		})
-	}
-	return imageURLs
+// fetchPage is a helper to get a goquery document from a URL, retrying
+// transient failures according to the configured retry policy.
+func (s *Scraper) fetchPage(ctx context.Context, url string, opts RequestOptions) (*goquery.Document, error) {
+	var doc *goquery.Document
+	_, err := s.retry.Do(ctx, func() error {
+		var err error
+		doc, err = s.fetchPageOnce(ctx, url, opts)
+		return err
+	})
+	return doc, err
 }
 
-// fetchPage is a helper to get a goquery document from a URL.
-func fetchPage(url string) (*goquery.Document, error) {
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("User-Agent", config.DefaultUserAgent)
-	resp, err := httpClient.Do(req)
+func (s *Scraper) fetchPageOnce(ctx context.Context, url string, opts RequestOptions) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, err
+		return nil, retry.Permanent(err)
 	}
-	defer resp.Body.Close()
-	return goquery.NewDocumentFromReader(resp.Body)
-}
-
-// getImageURL extracts the best available source URL from an image element.
-func getImageURL(s *goquery.Selection) string {
-	url, _ := s.Attr("data-src")
-	if url == "" {
-		url, _ = s.Attr("src")
+	req.Header.Set("User-Agent", s.cfg.UserAgent)
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if err := s.limiter.Wait(ctx, req.URL.Host, opts.Limit); err != nil {
+		return nil, err
 	}
-	if url == "" {
-		url, _ = s.Attr("data-lazy-src")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	if url == "" || url == "#" || strings.HasPrefix(url, "data:image") {
-		return ""
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		s.limiter.Penalize(req.URL.Host)
 	}
-	if url != "" && !strings.HasPrefix(url, "http") {
-		if strings.HasPrefix(url, "//") {
-			url = "https:" + url
-		} else {
-			url = "https://mangakatana.com" + url
+	if resp.StatusCode != http.StatusOK {
+		return nil, &retry.StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: retry.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
 		}
 	}
-	lower := strings.ToLower(url)
-	if !strings.Contains(lower, ".jpg") && !strings.Contains(lower, ".jpeg") &&
-		!strings.Contains(lower, ".png") && !strings.Contains(lower, ".webp") {
-		return ""
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
 	}
-	return url
+	doc.Url = resp.Request.URL
+	return doc, nil
 }
@@ -1,9 +1,14 @@
 package scraper
 
 import (
+	"slices"
 	"strings"
 	"testing"
 
+	"mangadl/internal/config"
+	"mangadl/internal/domain"
+	"mangadl/internal/ratelimit"
+
 	"github.com/PuerkitoBio/goquery"
 )
 
@@ -101,10 +106,182 @@ func TestGetImageURL(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			doc, _ := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
 			sel := doc.Find("img").First()
-			got := getImageURL(sel)
+			got := getImageURL(doc, sel)
 			if got != tt.expected {
 				t.Errorf("expected %q, got %q", tt.expected, got)
 			}
 		})
 	}
 }
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		url     string
+		source  string
+		wantErr bool
+	}{
+		{"https://mangakatana.com/manga/one-piece.20", "MangaKatana", false},
+		{"https://www.mangakatana.com/manga/one-piece.20", "MangaKatana", false},
+		{"https://example.com/manga/one-piece", "", true},
+		{"mangakatana.com/manga/one-piece.20", "", true},
+	}
+
+	for _, tt := range tests {
+		src, err := Lookup(tt.url)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Lookup(%q): expected error, got %s", tt.url, src.Name())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Lookup(%q): unexpected error: %v", tt.url, err)
+			continue
+		}
+		if src.Name() != tt.source {
+			t.Errorf("Lookup(%q) = %s; want %s", tt.url, src.Name(), tt.source)
+		}
+	}
+}
+
+func TestMangaKatanaChapters(t *testing.T) {
+	html := `
+		<html>
+		<body>
+			<h1 class="heading">One Piece</h1>
+			<div class="value"><a class="author" href="/author/oda">Oda Eiichiro</a></div>
+			<div class="genres"><a href="/genre/action">Action</a><a href="/genre/adventure">Adventure</a></div>
+			<div class="summary"><p> Gol D. Roger was known as the Pirate King. </p></div>
+			<div class="chapters">
+				<a href="/manga/one-piece.20/c2">Chapter 2</a>
+				<a href="https://mangakatana.com/manga/one-piece.20/c1">Chapter 1</a>
+				<a href="/manga/one-piece.20/c2">Chapter 2</a>
+				<a href="/manga/one-piece.20">Not a chapter</a>
+			</div>
+		</body>
+		</html>
+	`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("Failed to parse HTML: %v", err)
+	}
+
+	src := MangaKatana{}
+	details, err := src.Series(doc)
+	if err != nil {
+		t.Fatalf("Series: %v", err)
+	}
+	if details.Title != "One Piece" {
+		t.Errorf("expected title %q, got %q", "One Piece", details.Title)
+	}
+	if details.Summary != "Gol D. Roger was known as the Pirate King." {
+		t.Errorf("unexpected summary %q", details.Summary)
+	}
+	if len(details.Authors) != 1 || details.Authors[0] != "Oda Eiichiro" {
+		t.Errorf("unexpected authors %v", details.Authors)
+	}
+	if len(details.Genres) != 2 || details.Genres[1] != "Adventure" {
+		t.Errorf("unexpected genres %v", details.Genres)
+	}
+
+	chapters := src.Chapters(doc)
+	expected := []string{
+		"https://mangakatana.com/manga/one-piece.20/c2",
+		"https://mangakatana.com/manga/one-piece.20/c1",
+	}
+	if len(chapters) != len(expected) {
+		t.Fatalf("Expected %d chapters, got %d", len(expected), len(chapters))
+	}
+	for i, c := range chapters {
+		if c.URL != expected[i] {
+			t.Errorf("chapter %d: expected %q, got %q", i, expected[i], c.URL)
+		}
+	}
+}
+
+func TestAssignChapterIDs(t *testing.T) {
+	src := MangaKatana{}
+	listed := []domain.Chapter{
+		{Name: "Chapter 3", URL: "https://mangakatana.com/manga/one-piece.20/c3"},
+		{Name: "Chapter 2", URL: "https://mangakatana.com/manga/one-piece.20/c2"},
+		{Name: "Chapter 2.5", URL: "https://mangakatana.com/manga/one-piece.20/c2.5"},
+		{Name: "Chapter 1", URL: "https://mangakatana.com/manga/one-piece.20/c1"},
+	}
+	chapters := slices.Clone(listed)
+	AssignChapterIDs(src, chapters)
+	want := []string{"c3", "c2", "c2.5", "c1"}
+	for i, c := range chapters {
+		if c.ID != want[i] || c.Index != i {
+			t.Errorf("chapter %d: ID %q, index %d; want %q, %d", i, c.ID, c.Index, want[i], i)
+		}
+	}
+	if chapters[2].Number.String() != "2.5" {
+		t.Errorf("Number = %v; want 2.5", chapters[2].Number)
+	}
+
+	// The same chapters listed in another order, under a new domain and
+	// slug, keep their IDs.
+	reordered := []domain.Chapter{
+		{Name: "Chapter 1", URL: "https://www.mangakatana.com/manga/one-piece-new.20/c1"},
+		{Name: "Chapter 4", URL: "https://www.mangakatana.com/manga/one-piece-new.20/c4"},
+		{Name: "Chapter 3", URL: "https://www.mangakatana.com/manga/one-piece-new.20/c3/"},
+	}
+	AssignChapterIDs(src, reordered)
+	for i, want := range []string{"c1", "c4", "c3"} {
+		if reordered[i].ID != want {
+			t.Errorf("reordered chapter %d: ID %q; want %q", i, reordered[i].ID, want)
+		}
+	}
+
+	// Chapters sharing a key get distinct IDs, by number and then by index.
+	dupes := []domain.Chapter{
+		{Name: "Chapter 7", URL: "https://example.com/read?id=7"},
+		{Name: "Chapter 7.5", URL: "https://example.com/read?id=7"},
+		{Name: "Chapter 7", URL: "https://example.com/read?id=7"},
+		{Name: "Chapter 7", URL: "https://example.com/read?id=7"},
+		{Name: "Oneshot", URL: "::"},
+		{Name: "Oneshot", URL: "::"},
+		{Name: "Chapter 12", URL: "https://example.com/read?id=12"},
+		{Name: "Chapter 12.5", URL: "https://example.com/read?id=12"},
+		{Name: "Chapter 12.05", URL: "https://example.com/read?id=12"},
+		{Name: "Chapter 12.25", URL: "https://example.com/read?id=12"},
+	}
+	AssignChapterIDs(nil, dupes)
+	seen := make(map[string]bool)
+	for i, c := range dupes {
+		if c.ID == "" || seen[c.ID] {
+			t.Errorf("chapter %d: ID %q is empty or taken (%+v)", i, c.ID, dupes)
+		}
+		seen[c.ID] = true
+	}
+	wantIDs := []string{"read?id=7", "read?id=7#ch7.5", "read?id=7#ch7", "read?id=7#idx3", "#ch0", "#idx5",
+		"read?id=12", "read?id=12#ch12.5", "read?id=12#ch12.05", "read?id=12#ch12.25"}
+	for i, want := range wantIDs {
+		if dupes[i].ID != want {
+			t.Errorf("duplicate chapter %d: ID %q; want %q", i, dupes[i].ID, want)
+		}
+	}
+}
+
+func TestRateLimitFor(t *testing.T) {
+	cfg := config.Default()
+	def := ratelimit.Limit{RPS: cfg.RateLimit.RPS, Burst: cfg.RateLimit.Burst}
+	if got := New(cfg).optionsFor(MangaKatana{}).Limit; got != def {
+		t.Errorf("default limit = %+v; want %+v", got, def)
+	}
+
+	cfg.RateLimit = config.RateLimitConfig{RPS: 1, Burst: 2}
+	global := ratelimit.Limit{RPS: 1, Burst: 2}
+	if got := New(cfg).optionsFor(MangaKatana{}).Limit; got != global {
+		t.Errorf("global override = %+v; want %+v", got, global)
+	}
+
+	cfg.Sources = map[string]config.RateLimitConfig{"MangaKatana": {RPS: 3, Burst: 4}}
+	own := ratelimit.Limit{RPS: 3, Burst: 4}
+	if got := New(cfg).optionsFor(MangaKatana{}).Limit; got != own {
+		t.Errorf("source override = %+v; want %+v", got, own)
+	}
+	if got := New(cfg).optionsFor(nil).Limit; got != global {
+		t.Errorf("unknown source = %+v; want %+v", got, global)
+	}
+}
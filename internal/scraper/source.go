@@ -0,0 +1,105 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"mangadl/internal/domain"
+	"mangadl/internal/ratelimit"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ErrUnsupportedSource is returned when no registered source claims a URL.
+var ErrUnsupportedSource = errors.New("unsupported site")
+
+// Source is a manga site the scraper knows how to read. Fetching is done by
+// the scraper itself; a source only recognises its URLs and parses its pages.
+type Source interface {
+	// Name is the human-readable site name, e.g. "MangaKatana".
+	Name() string
+	// Match reports whether the source handles the given URL.
+	Match(u *url.URL) bool
+	// Headers returns extra request headers the site expects (e.g. Referer).
+	Headers() map[string]string
+	// Series extracts the series metadata (title, cover...) from a series page.
+	Series(doc *goquery.Document) (*domain.MangaDetails, error)
+	// Chapters extracts the chapter list from a series page.
+	Chapters(doc *goquery.Document) []domain.Chapter
+	// Pages extracts the image URLs from a chapter page, in reading order.
+	Pages(doc *goquery.Document) []string
+}
+
+// RateLimited is implemented by sources that need a request rate other than
+// the configured default. A per-source entry in the config still wins.
+type RateLimited interface {
+	RateLimit() ratelimit.Limit
+}
+
+var (
+	sourcesMu sync.RWMutex
+	sources   []Source
+)
+
+// Register adds a source to the registry. Sources are matched in
+// registration order, so more specific sources should register first.
+func Register(s Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources = append(sources, s)
+}
+
+// Sources returns all registered sources.
+func Sources() []Source {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	return append([]Source(nil), sources...)
+}
+
+// SourceNames returns the names of all registered sources.
+func SourceNames() []string {
+	var names []string
+	for _, s := range Sources() {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+// Lookup returns the source that claims rawURL.
+func Lookup(rawURL string) (Source, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL %q: expected http or https", rawURL)
+	}
+	for _, s := range Sources() {
+		if s.Match(u) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedSource, u.Host)
+}
+
+// resolveURL resolves href against the document URL, falling back to base
+// when the document was not fetched over HTTP (e.g. in tests).
+func resolveURL(doc *goquery.Document, base, href string) string {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if ref.IsAbs() {
+		return ref.String()
+	}
+	baseURL := doc.Url
+	if baseURL == nil {
+		if baseURL, err = url.Parse(base); err != nil {
+			return ""
+		}
+	}
+	return baseURL.ResolveReference(ref).String()
+}
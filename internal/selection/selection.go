@@ -0,0 +1,255 @@
+// Package selection parses chapter selections such as
+// "1-20,25,30.5,latest:5,!12,vol:3" and resolves them against a series'
+// chapter list.
+package selection
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"mangadl/internal/domain"
+)
+
+// Selection is a parsed chapter selection. It is a comma-separated list of
+// terms:
+//
+//	12          chapter 12 (and 12.5 only if written as 12.5)
+//	1-20        chapters 1 to 20 inclusive, including 10.5 and the like
+//	20-  -10    chapters from 20 on, and up to 10
+//	latest:5    the last 5 chapters in reading order
+//	vol:3       every chapter of volume 3; vol:1-3 for a range of volumes
+//	all         every chapter
+//
+// A term prefixed with ! excludes the chapters it matches. The result is
+// every chapter matched by a term without ! (or every chapter if there are
+// none) minus the excluded ones, so "!12" selects everything but chapter 12.
+// Number terms only match numbered chapters; use vol: or all to pick up
+// extras without a number.
+type Selection struct {
+	text             string
+	include, exclude []term
+}
+
+type termKind int
+
+const (
+	termAll termKind = iota
+	termNumber
+	termLatest
+	termVolume
+)
+
+// term is one comma-separated element. lo and hi bound chapter numbers for
+// termNumber and volumes for termVolume; n is the count for termLatest.
+type term struct {
+	kind   termKind
+	lo, hi float64
+	n      int
+}
+
+// Parse parses spec. An empty spec selects every chapter.
+func Parse(spec string) (*Selection, error) {
+	s := &Selection{text: strings.TrimSpace(spec)}
+	for _, raw := range strings.Split(spec, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		text, exclude := strings.CutPrefix(raw, "!")
+		t, err := parseTerm(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q: %w", raw, err)
+		}
+		if exclude {
+			s.exclude = append(s.exclude, t)
+		} else {
+			s.include = append(s.include, t)
+		}
+	}
+	return s, nil
+}
+
+func parseTerm(text string) (term, error) {
+	key, value, hasKey := strings.Cut(text, ":")
+	if hasKey {
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "latest", "last":
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < 1 {
+				return term{}, errors.New("latest: wants a positive chapter count")
+			}
+			return term{kind: termLatest, n: n}, nil
+		case "vol", "volume":
+			lo, hi, err := parseRange(value)
+			if err != nil {
+				return term{}, fmt.Errorf("vol: %w", err)
+			}
+			return term{kind: termVolume, lo: lo, hi: hi}, nil
+		}
+		return term{}, fmt.Errorf("unknown selector %q (want latest: or vol:)", key)
+	}
+	if strings.EqualFold(text, "all") || text == "*" {
+		return term{kind: termAll}, nil
+	}
+	lo, hi, err := parseRange(text)
+	if err != nil {
+		return term{}, err
+	}
+	return term{kind: termNumber, lo: lo, hi: hi}, nil
+}
+
+// parseRange parses "7", "1-20", "20-" or "-10" into inclusive bounds.
+func parseRange(text string) (lo, hi float64, err error) {
+	text = strings.TrimSpace(text)
+	from, to, isRange := strings.Cut(text, "-")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !isRange {
+		to = from
+	}
+	if from == "" && to == "" {
+		return 0, 0, errors.New("want a number or range such as 1-20")
+	}
+
+	lo, hi = 0, math.Inf(1)
+	if from != "" {
+		if lo, err = parseNumber(from); err != nil {
+			return 0, 0, err
+		}
+	}
+	if to != "" {
+		if hi, err = parseNumber(to); err != nil {
+			return 0, 0, err
+		}
+	}
+	if hi < lo {
+		return 0, 0, fmt.Errorf("range %s is reversed", text)
+	}
+	return lo, hi, nil
+}
+
+func parseNumber(text string) (float64, error) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%q is not a chapter number", text)
+	}
+	return f, nil
+}
+
+// String returns the selection as written.
+func (s *Selection) String() string { return s.text }
+
+// Select returns the chapters the selection matches, in the order given.
+// chapters must be in reading order for latest: to pick the newest ones.
+func (s *Selection) Select(chapters []domain.Chapter) []domain.Chapter {
+	picked := make([]bool, len(chapters))
+	if len(s.include) == 0 {
+		for i := range picked {
+			picked[i] = true
+		}
+	}
+	for _, t := range s.include {
+		t.mark(chapters, picked, true)
+	}
+	for _, t := range s.exclude {
+		t.mark(chapters, picked, false)
+	}
+
+	var selected []domain.Chapter
+	for i, c := range chapters {
+		if picked[i] {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// mark sets picked to value for the chapters t matches.
+func (t term) mark(chapters []domain.Chapter, picked []bool, value bool) {
+	if t.kind == termLatest {
+		for i := max(0, len(chapters)-t.n); i < len(chapters); i++ {
+			picked[i] = value
+		}
+		return
+	}
+	for i, c := range chapters {
+		if t.matches(c) {
+			picked[i] = value
+		}
+	}
+}
+
+func (t term) matches(c domain.Chapter) bool {
+	num := c.ParsedNumber()
+	switch t.kind {
+	case termAll:
+		return true
+	case termNumber:
+		f := num.Float()
+		return num.Numbered && f >= t.lo && f <= t.hi
+	case termVolume:
+		vol := float64(num.Volume)
+		if num.Volume == 0 {
+			v, err := strconv.ParseFloat(c.Volume, 64)
+			if err != nil {
+				return false
+			}
+			vol = v
+		}
+		return vol >= t.lo && vol <= t.hi
+	}
+	return false
+}
+
+// Describe summarises chapters for a preview, such as "1-20, 25, 30.5 and
+// 2 unnumbered". Runs of consecutive whole numbers are collapsed into
+// ranges; chapters is expected in reading order.
+func Describe(chapters []domain.Chapter) string {
+	var parts []string
+	unnumbered := 0
+	var runStart, runEnd domain.ChapterNumber
+	inRun := false
+	flush := func() {
+		if !inRun {
+			return
+		}
+		if runStart == runEnd {
+			parts = append(parts, runStart.String())
+		} else {
+			parts = append(parts, runStart.String()+"-"+runEnd.String())
+		}
+		inRun = false
+	}
+
+	for _, c := range chapters {
+		num := c.ParsedNumber()
+		if !num.Numbered {
+			unnumbered++
+			continue
+		}
+		whole := num.Sub == ""
+		if inRun && whole && runEnd.Sub == "" && num.Main == runEnd.Main+1 {
+			runEnd = num
+			continue
+		}
+		if inRun && num.Main == runEnd.Main && num.Sub == runEnd.Sub && num.Part == runEnd.Part {
+			continue // the same number listed twice
+		}
+		flush()
+		runStart, runEnd, inRun = num, num, true
+	}
+	flush()
+
+	if unnumbered > 0 {
+		parts = append(parts, fmt.Sprintf("%d unnumbered", unnumbered))
+	}
+	switch len(parts) {
+	case 0:
+		return "none"
+	case 1:
+		return parts[0]
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
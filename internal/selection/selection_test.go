@@ -0,0 +1,105 @@
+package selection
+
+import (
+	"slices"
+	"testing"
+
+	"mangadl/internal/domain"
+)
+
+var chapters = func() []domain.Chapter {
+	names := []string{
+		"Vol.1 Chapter 1", "Vol.1 Chapter 2", "Vol.1 Chapter 3", "Vol.1 Extra",
+		"Vol.2 Chapter 4", "Vol.2 Chapter 5", "Vol.2 Chapter 5.5",
+		"Chapter 6", "Chapter 7", "Chapter 8",
+	}
+	var cs []domain.Chapter
+	for _, name := range names {
+		cs = append(cs, domain.Chapter{Name: name, ID: name})
+	}
+	return cs
+}()
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"", []string{"Vol.1 Chapter 1", "Vol.1 Chapter 2", "Vol.1 Chapter 3", "Vol.1 Extra",
+			"Vol.2 Chapter 4", "Vol.2 Chapter 5", "Vol.2 Chapter 5.5", "Chapter 6", "Chapter 7", "Chapter 8"}},
+		{"1-3", []string{"Vol.1 Chapter 1", "Vol.1 Chapter 2", "Vol.1 Chapter 3"}},
+		{"5", []string{"Vol.2 Chapter 5"}},
+		{"5.5, 8", []string{"Vol.2 Chapter 5.5", "Chapter 8"}},
+		{"4-6", []string{"Vol.2 Chapter 4", "Vol.2 Chapter 5", "Vol.2 Chapter 5.5", "Chapter 6"}},
+		{"7-", []string{"Chapter 7", "Chapter 8"}},
+		{"-2", []string{"Vol.1 Chapter 1", "Vol.1 Chapter 2"}},
+		{"latest:2", []string{"Chapter 7", "Chapter 8"}},
+		{"vol:1", []string{"Vol.1 Chapter 1", "Vol.1 Chapter 2", "Vol.1 Chapter 3", "Vol.1 Extra"}},
+		{"vol:2,latest:1,!5.5", []string{"Vol.2 Chapter 4", "Vol.2 Chapter 5", "Chapter 8"}},
+		{"!1-7", []string{"Vol.1 Extra", "Chapter 8"}},
+		{"all,!vol:1-2", []string{"Chapter 6", "Chapter 7", "Chapter 8"}},
+		{"100", nil},
+	}
+	for _, tt := range tests {
+		sel, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		var got []string
+		for _, c := range sel.Select(chapters) {
+			got = append(got, c.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Select(%q) = %q; want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestSelectParts(t *testing.T) {
+	var parts []domain.Chapter
+	for _, name := range []string{"Chapter 10-2", "Chapter 10-10", "Chapter 10.1", "Chapter 11"} {
+		parts = append(parts, domain.Chapter{Name: name, ID: name})
+	}
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"10.1", []string{"Chapter 10.1"}},
+		{"10-10.5", []string{"Chapter 10-2", "Chapter 10-10", "Chapter 10.1"}},
+	}
+	for _, tt := range tests {
+		sel, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		var got []string
+		for _, c := range sel.Select(parts) {
+			got = append(got, c.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Select(%q) = %q; want %q", tt.spec, got, tt.want)
+		}
+	}
+	if got, want := Describe(parts), "10-2, 10-10, 10.1 and 11"; got != want {
+		t.Errorf("Describe = %q; want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"abc", "5-1", "1-x", "latest:0", "latest:x", "vol:", "newest:3", "-", "!"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): expected error", spec)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	sel, _ := Parse("!7")
+	if got, want := Describe(sel.Select(chapters)), "1-5, 5.5, 6, 8 and 1 unnumbered"; got != want {
+		t.Errorf("Describe = %q; want %q", got, want)
+	}
+	if got := Describe(nil); got != "none" {
+		t.Errorf("Describe(nil) = %q; want none", got)
+	}
+}
@@ -11,7 +11,7 @@ import (
 )
 
 type ChapterDelegate struct {
-	Selected map[int]struct{}
+	Selected map[string]struct{}
 }
 
 func (d ChapterDelegate) Height() int                             { return 1 }
@@ -1,12 +1,33 @@
 package ui
 
-import "mangadl/internal/domain"
+import (
+	"mangadl/internal/domain"
+	"mangadl/internal/queue"
+)
 
 type MangaFetchedMsg *domain.MangaDetails
 type ErrMsg error
-type ProgressMsg struct {
-	Done    int // -1 for increment
-	Total   int
-	Message string
-}
+
+// QueueEventMsg is an event from the download queue.
+type QueueEventMsg queue.Event
+
+// DownloadCompleteMsg is sent when the queue's event stream ends.
 type DownloadCompleteMsg struct{}
+
+// VolumesWrittenMsg is sent once the volumes of the finished chapters have
+// been written, with a log line per volume.
+type VolumesWrittenMsg []string
+
+// RetryBatch holds the failed chapters of one series to download again.
+type RetryBatch struct {
+	Series   *domain.MangaDetails
+	Dir      string // series directory of the failed attempts
+	Chapters []domain.Chapter
+}
+
+// RetryFetchedMsg carries the series of failed history entries, fetched
+// again, and a description of each entry that cannot be retried.
+type RetryFetchedMsg struct {
+	Batches  []RetryBatch
+	Problems []string
+}
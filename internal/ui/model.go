@@ -1,6 +1,10 @@
 package ui
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
@@ -9,7 +13,12 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 
+	"mangadl/internal/config"
 	"mangadl/internal/domain"
+	"mangadl/internal/downloader"
+	"mangadl/internal/history"
+	"mangadl/internal/queue"
+	"mangadl/internal/scraper"
 )
 
 type Status int
@@ -21,14 +30,29 @@ const (
 	StatusDownloading
 	StatusDone
 	StatusError
+	StatusHistory
 )
 
+// ChapterOutcome is a finished chapter as shown on the done screen.
+type ChapterOutcome struct {
+	Result domain.ChapterResult
+	Err    error
+}
+
 type Model struct {
+	Config     config.Config
+	Scraper    *scraper.Scraper
+	Downloader *downloader.Downloader
+	History    *history.Store // finished chapters are recorded here; may be nil
+	Log        *slog.Logger   // receives every dashboard log line
+
 	State       Status
 	TextInput   textinput.Model
 	FilterInput textinput.Model
+	RangeInput  textinput.Model // selection expression, see package selection
 	Spinner     spinner.Model
 	Progress    progress.Model
+	ChapterBar  progress.Model // rendered statically for each running chapter
 	Viewport    viewport.Model
 
 	// Logs for the dashboard
@@ -39,7 +63,7 @@ type Model struct {
 	Err   error
 
 	// Selection state
-	Selected         map[int]struct{} // Key is Chapter.ID
+	Selected         map[string]struct{} // Key is Chapter.ID
 	FilteredChapters []domain.Chapter
 	SelectionCursor  int
 	SelectionOffset  int
@@ -47,17 +71,109 @@ type Model struct {
 	SelectionRows    int
 
 	// Download state
+	Queue         *queue.Queue // created by the first download, then reused
+	queueEvents   <-chan queue.Event
 	TotalChapters int
 	DoneChapters  int
+	Active        []queue.Job        // running chapters in the order they started, with their progress
+	Bytes         int64              // image bytes received since the first download started
+	jobBytes      map[queue.ID]int64 // bytes of each running attempt already counted in Bytes
 	CurrentStatus string
 	StartTime     time.Time
+	Results       []ChapterOutcome
+	Paused        bool // every job was paused with p
+	Cancelled     bool // the download was stopped before finishing
+	quitting      bool // quit once the cancelled download has stopped
+
+	// Chapters finished since volumes were last written, by series
+	// directory, when the volumes setting is on.
+	volumes        map[string]*volumeBatch
+	writingVolumes bool
+
+	// History screen state
+	HistoryEntries    []history.Entry // oldest first, as loaded
+	HistoryCursor     int
+	HistoryFailedOnly bool   // list only chapters whose last attempt failed
+	HistoryNotice     string // outcome of the last retry request
+	historyReturn     Status // screen the history was opened from
 
 	// Window size
 	Width  int
 	Height int
 }
 
-func InitialModel() Model {
+// Speed returns the combined download speed of the running chapters in
+// bytes per second.
+func (m Model) Speed() float64 {
+	var speed float64
+	for _, j := range m.Active {
+		speed += j.Progress.Speed
+	}
+	return speed
+}
+
+// Completion returns the finished fraction of the queued chapters, counting
+// the pages saved of the running ones.
+func (m Model) Completion() float64 {
+	if m.TotalChapters == 0 {
+		return 0
+	}
+	done := float64(m.DoneChapters)
+	for _, j := range m.Active {
+		if p := j.Progress; p.PagesTotal > 0 {
+			done += float64(p.PagesDone) / float64(p.PagesTotal)
+		}
+	}
+	return min(1, done/float64(m.TotalChapters))
+}
+
+// ETA estimates the time left from the time spent since StartTime and the
+// fraction completed so far. It returns false while there is no estimate.
+func (m Model) ETA() (time.Duration, bool) {
+	done := m.Completion()
+	if done <= 0 || m.Paused || m.Cancelled {
+		return 0, false
+	}
+	elapsed := time.Since(m.StartTime)
+	return time.Duration(float64(elapsed) * (1 - done) / done), true
+}
+
+// VisibleHistory returns the history entries listed on the history screen,
+// newest first.
+func (m Model) VisibleHistory() []history.Entry {
+	entries := m.HistoryEntries
+	if m.HistoryFailedOnly {
+		entries = history.Failed(entries)
+	}
+	entries = slices.Clone(entries)
+	slices.Reverse(entries)
+	return entries
+}
+
+// FailedResults returns the outcomes of chapters that failed, leaving out
+// those stopped by a cancel.
+func (m Model) FailedResults() []ChapterOutcome {
+	var failed []ChapterOutcome
+	for _, r := range m.Results {
+		if r.Err != nil && !errors.Is(r.Err, context.Canceled) {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// InterruptedResults returns the outcomes of chapters stopped by a cancel.
+func (m Model) InterruptedResults() []ChapterOutcome {
+	var interrupted []ChapterOutcome
+	for _, r := range m.Results {
+		if errors.Is(r.Err, context.Canceled) {
+			interrupted = append(interrupted, r)
+		}
+	}
+	return interrupted
+}
+
+func InitialModel(cfg config.Config, sc *scraper.Scraper, dl *downloader.Downloader) Model {
 	ti := textinput.New()
 	ti.Placeholder = "Paste URL here..."
 	ti.Focus()
@@ -76,6 +192,12 @@ func InitialModel() Model {
 		progress.WithoutPercentage(),
 	)
 
+	chapterBar := progress.New(
+		progress.WithSolidFill(string(Cyan)),
+		progress.WithWidth(20),
+		progress.WithoutPercentage(),
+	)
+
 	fi := textinput.New()
 	fi.Placeholder = "Filter chapters..."
 	fi.CharLimit = 50
@@ -83,13 +205,26 @@ func InitialModel() Model {
 	// Style similar to main input but smaller?
 	fi.Prompt = "/ "
 
+	ri := textinput.New()
+	ri.Placeholder = "1-20,25,latest:5,!12,vol:3"
+	ri.CharLimit = 200
+	ri.Width = 40
+	ri.Prompt = "> "
+
 	return Model{
+		Config:      cfg,
+		Scraper:     sc,
+		Downloader:  dl,
+		Log:         slog.New(slog.DiscardHandler),
 		State:       StatusInput,
 		TextInput:   ti,
 		FilterInput: fi,
+		RangeInput:  ri,
 		Spinner:     s,
 		Progress:    prog,
-		Selected:    make(map[int]struct{}),
+		ChapterBar:  chapterBar,
+		Selected:    make(map[string]struct{}),
+		jobBytes:    make(map[queue.ID]int64),
 		Logs:        []string{},
 	}
 }
@@ -1,9 +1,10 @@
 package ui
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
@@ -11,9 +12,13 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 
+	"mangadl/internal/config"
 	"mangadl/internal/domain"
 	"mangadl/internal/downloader"
+	"mangadl/internal/history"
+	"mangadl/internal/queue"
 	"mangadl/internal/scraper"
+	"mangadl/internal/selection"
 )
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -21,21 +26,40 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		// Global Quit
+		// Global Quit. A running download is cancelled first so the pages
+		// saved so far are recorded for resuming; a second Ctrl+C quits
+		// without waiting.
 		if msg.Type == tea.KeyCtrlC {
+			if m.State == StatusDownloading && !m.quitting {
+				m.quitting = true
+				m.cancelDownload()
+				return m, nil
+			}
 			return m, tea.Quit
 		}
 
 		// Contextual Key Handling
 		switch m.State {
 		case StatusInput:
+			if msg.Type == tea.KeyCtrlR {
+				m.openHistory()
+				return m, nil
+			}
 			if msg.Type == tea.KeyEnter {
 				if m.TextInput.Value() != "" {
 					m.State = StatusFetching
-					return m, fetchMangaCmd(m.TextInput.Value())
+					return m, fetchMangaCmd(context.Background(), m.Scraper, m.TextInput.Value())
 				}
 			}
 			if msg.Type == tea.KeyEsc {
+				if m.Queue != nil {
+					// Back to the download, which may have finished meanwhile.
+					m.State = StatusDownloading
+					if m.downloadOver() {
+						return m, m.finishDownload()
+					}
+					return m, nil
+				}
 				return m, tea.Quit
 			}
 
@@ -54,21 +78,57 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, cmd
 			}
 
+			// Handle the selection prompt. Enter replaces the selection
+			// with the chapters the expression resolves to; the preview
+			// is shown while typing.
+			if m.RangeInput.Focused() {
+				switch msg.Type {
+				case tea.KeyEsc:
+					m.RangeInput.Blur()
+					return m, nil
+				case tea.KeyEnter:
+					sel, err := selection.Parse(m.RangeInput.Value())
+					if err != nil {
+						return m, nil
+					}
+					m.Selected = make(map[string]struct{})
+					for _, c := range sel.Select(m.Manga.Chapters) {
+						m.Selected[c.ID] = struct{}{}
+					}
+					m.RangeInput.Blur()
+					return m, nil
+				}
+
+				var cmd tea.Cmd
+				m.RangeInput, cmd = m.RangeInput.Update(msg)
+				return m, cmd
+			}
+
 			switch msg.String() {
 			case "/":
 				m.FilterInput.Focus()
 				return m, textinput.Blink
 
+			case "s":
+				m.RangeInput.Focus()
+				return m, textinput.Blink
+
 			case "enter":
-				// Start Download
+				// Start Download, or add to the one running
 				chapters := m.getSelectedChapters()
 				if len(chapters) > 0 {
+					cmd := m.startQueue()
+					mangaDir, err := downloader.SeriesDir(m.Config, m.Manga)
+					if err == nil {
+						err = m.enqueue(m.Manga, mangaDir, chapters)
+					}
+					if err != nil {
+						m.Err = err
+						m.State = StatusError
+						return m, cmd
+					}
 					m.State = StatusDownloading
-					m.TotalChapters = len(chapters)
-					m.DoneChapters = 0
-					m.StartTime = time.Now()
-					m.addLog("Initializing download sequence...")
-					return m, startDownload(chapters, m.Manga.Title)
+					return m, cmd
 				}
 
 			case " ":
@@ -115,7 +175,58 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.moveCursor(1)
 			}
 
+		case StatusDownloading:
+			switch msg.String() {
+			case "c":
+				if !m.Cancelled {
+					m.cancelDownload()
+				}
+			case "p":
+				if !m.Cancelled {
+					m.togglePause()
+				}
+			case "n":
+				// Queue chapters of another series while this one runs.
+				if !m.Cancelled {
+					m.TextInput.SetValue("")
+					m.TextInput.Focus()
+					m.State = StatusInput
+					return m, textinput.Blink
+				}
+			case "h":
+				m.openHistory()
+			}
+
+		case StatusHistory:
+			switch msg.String() {
+			case "up", "k":
+				m.HistoryCursor = max(0, m.HistoryCursor-1)
+			case "down", "j":
+				m.HistoryCursor = max(0, min(len(m.VisibleHistory())-1, m.HistoryCursor+1))
+			case "f":
+				m.HistoryFailedOnly = !m.HistoryFailedOnly
+				m.HistoryCursor = 0
+			case "r":
+				entries := m.VisibleHistory()
+				if m.HistoryCursor < len(entries) && entries[m.HistoryCursor].Status == history.StatusFailed {
+					return m, m.retry(entries[m.HistoryCursor : m.HistoryCursor+1])
+				}
+				m.HistoryNotice = "Only failed chapters can be retried"
+			case "R":
+				if failed := history.Failed(m.HistoryEntries); len(failed) > 0 {
+					return m, m.retry(failed)
+				}
+				m.HistoryNotice = "No failed chapters to retry"
+			case "esc", "q":
+				return m, m.closeHistory()
+			}
+			return m, nil
+
 		case StatusDone:
+			if msg.String() == "h" {
+				m.openHistory()
+				return m, nil
+			}
 			if msg.Type == tea.KeyEnter || msg.Type == tea.KeyEsc || msg.String() == "q" {
 				return m, tea.Quit
 			}
@@ -143,7 +254,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.recalcLayout()
 
 		// Default: Select All
-		m.Selected = make(map[int]struct{})
+		m.Selected = make(map[string]struct{})
 		for _, c := range m.Manga.Chapters {
 			m.Selected[c.ID] = struct{}{}
 		}
@@ -152,32 +263,54 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.Err = msg
 		m.State = StatusError
 
-	case ProgressMsg:
-		if msg.Done == -1 {
-			m.DoneChapters++
-		} else if msg.Done >= 0 {
-			m.DoneChapters = msg.Done
+	case QueueEventMsg:
+		cmds := []tea.Cmd{waitForQueueEvent(m.queueEvents)}
+		if m.handleQueueEvent(queue.Event(msg)) {
+			if m.quitting {
+				return m, tea.Quit
+			}
+			cmds = append(cmds, m.finishDownload())
 		}
-		// If msg.Done == -2, do not change DoneChapters
+		cmds = append(cmds, m.Progress.SetPercent(m.Completion()))
+		return m, tea.Batch(cmds...)
 
-		if msg.Message != "" {
-			m.CurrentStatus = msg.Message
-			m.addLog(msg.Message)
+	case VolumesWrittenMsg:
+		m.writingVolumes = false
+		for _, line := range msg {
+			m.addLog(line)
+		}
+		if m.State == StatusDownloading && m.downloadOver() {
+			return m, m.finishDownload()
 		}
 
-		pct := float64(m.DoneChapters) / float64(m.TotalChapters)
-		if pct > 1.0 {
-			pct = 1.0
+	case RetryFetchedMsg:
+		for _, p := range msg.Problems {
+			m.addLog("Cannot retry " + p)
 		}
-		if m.TotalChapters == 0 {
-			pct = 0
+		if len(msg.Batches) == 0 {
+			m.State = StatusHistory
+			m.HistoryNotice = "No failed chapters to retry"
+			if len(msg.Problems) > 0 {
+				m.HistoryNotice = "Cannot retry " + msg.Problems[0]
+			}
+			return m, nil
 		}
-
-		cmd = m.Progress.SetPercent(pct)
-		return m, tea.Batch(cmd, waitForDownloadMsg)
+		cmd := m.startQueue()
+		for _, b := range msg.Batches {
+			if err := m.enqueue(b.Series, b.Dir, b.Chapters); err != nil {
+				m.Err = err
+				m.State = StatusError
+				return m, cmd
+			}
+		}
+		m.State = StatusDownloading
+		return m, cmd
 
 	case DownloadCompleteMsg:
 		m.State = StatusDone
+		if m.quitting {
+			return m, tea.Quit
+		}
 		return m, nil
 
 	case spinner.TickMsg:
@@ -209,9 +342,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
-func fetchMangaCmd(url string) tea.Cmd {
+func fetchMangaCmd(ctx context.Context, s *scraper.Scraper, url string) tea.Cmd {
 	return func() tea.Msg {
-		details, err := scraper.FetchMangaDetails(url)
+		details, err := s.FetchMangaDetails(ctx, url)
 		if err != nil {
 			return ErrMsg(err)
 		}
@@ -219,54 +352,264 @@ func fetchMangaCmd(url string) tea.Cmd {
 	}
 }
 
-var downloadChan chan ProgressMsg
+// newQueue returns the download queue, running cfg.MaxChapterWorkers
+// chapters at once and at most cfg.MaxSeriesWorkers of one series. With
+// cfg.Volumes set, chapters are only fetched; finishDownload archives them
+// as volumes.
+func newQueue(d *downloader.Downloader, cfg config.Config) *queue.Queue {
+	download := d.DownloadChapter
+	if volumeSpec(cfg).Enabled() {
+		download = d.FetchChapter
+	}
+	run := func(ctx context.Context, job queue.Job, progress func(domain.ChapterProgress)) (*domain.ChapterResult, error) {
+		ctx = downloader.WithProgress(ctx, progress)
+		return download(ctx, job.Series, job.Chapter, job.Dir)
+	}
+	return queue.New(run, queue.Options{
+		Workers:   cfg.MaxChapterWorkers,
+		PerSeries: cfg.MaxSeriesWorkers,
+	})
+}
 
-func startDownload(chapters []domain.Chapter, title string) tea.Cmd {
-	downloadChan = make(chan ProgressMsg, 100)
+// volumeSpec returns the volumes setting of cfg, which Validate has
+// already accepted.
+func volumeSpec(cfg config.Config) config.VolumeSpec {
+	spec, _ := config.ParseVolumes(cfg.Volumes)
+	return spec
+}
 
-	go func() {
-		mangaDir := downloader.SanitizeFilename(title)
-		total := len(chapters)
+// volumeBatch holds the chapters of one series directory that finished
+// since its volumes were last written.
+type volumeBatch struct {
+	series   *domain.MangaDetails
+	dir      string
+	chapters []domain.Chapter
+	results  map[string]*domain.ChapterResult // successful chapters by Chapter.Key
+}
 
-		var wg sync.WaitGroup
-		sem := make(chan struct{}, 10) // Concurrency limit
+// collectVolume adds a finished job to its volume batch when volumes are
+// enabled; result is nil if the chapter did not succeed.
+func (m *Model) collectVolume(job queue.Job, result *domain.ChapterResult) {
+	if !volumeSpec(m.Config).Enabled() {
+		return
+	}
+	if m.volumes == nil {
+		m.volumes = make(map[string]*volumeBatch)
+	}
+	b := m.volumes[job.Dir]
+	if b == nil {
+		b = &volumeBatch{series: job.Series, dir: job.Dir, results: make(map[string]*domain.ChapterResult)}
+		m.volumes[job.Dir] = b
+	}
+	b.chapters = append(b.chapters, job.Chapter)
+	if result != nil {
+		b.results[job.Chapter.Key()] = result
+	}
+}
 
-		for _, chapter := range chapters {
-			wg.Add(1)
-			go func(ch domain.Chapter) {
-				defer wg.Done()
-				sem <- struct{}{}
+// finishDownload ends a download that is over. With volumes enabled, the
+// volumes of the chapters finished since they were last written are
+// written first, and the done screen follows their VolumesWrittenMsg.
+func (m *Model) finishDownload() tea.Cmd {
+	if m.writingVolumes {
+		return nil
+	}
+	if len(m.volumes) > 0 {
+		m.writingVolumes = true
+		m.CurrentStatus = "Writing volumes..."
+		m.addLog(m.CurrentStatus)
+		batches := m.volumes
+		m.volumes = nil
+		return writeVolumesCmd(m.Downloader, volumeSpec(m.Config), batches)
+	}
+	m.State = StatusDone
+	return nil
+}
 
-				select {
-				case downloadChan <- ProgressMsg{Done: -2, Total: total, Message: fmt.Sprintf("Started: %s", ch.Name)}:
-				default:
+// writeVolumesCmd archives the volumes of batches that hold a fetched
+// chapter and no failed one, as the download subcommand does.
+func writeVolumesCmd(d *downloader.Downloader, spec config.VolumeSpec, batches map[string]*volumeBatch) tea.Cmd {
+	return func() tea.Msg {
+		var lines VolumesWrittenMsg
+		for _, b := range batches {
+			for _, vol := range downloader.SelectVolumes(spec, b.series, b.chapters, b.results) {
+				if !vol.Stale {
+					continue
 				}
-
-				err := downloader.DownloadChapter(ch.URL, ch.Name, mangaDir)
-				<-sem
-
-				msg := fmt.Sprintf("Finished: %s", ch.Name)
+				archive, err := d.WriteVolume(context.Background(), b.series, vol.Volume, b.dir)
 				if err != nil {
-					msg = fmt.Sprintf("Failed: %s (%v)", ch.Name, err)
+					lines = append(lines, fmt.Sprintf("Failed: %s (%v)", vol.Title, err))
+					continue
 				}
+				lines = append(lines, fmt.Sprintf("Wrote %s: %s", vol.Title, archive))
+			}
+		}
+		return lines
+	}
+}
+
+// startQueue creates the download queue on the first download and returns
+// the command delivering its events; later downloads reuse the queue and
+// get a nil command.
+func (m *Model) startQueue() tea.Cmd {
+	m.Cancelled = false // whatever was cancelled has finished
+	if m.Queue != nil {
+		return nil
+	}
+	m.StartTime = time.Now()
+	m.addLog("Initializing download sequence...")
+	m.Queue = newQueue(m.Downloader, m.Config)
+	// The subscription lasts as long as the program.
+	m.queueEvents, _ = m.Queue.Subscribe()
+	return waitForQueueEvent(m.queueEvents)
+}
+
+// enqueue adds chapters of manga to the queue, to be saved under mangaDir.
+func (m *Model) enqueue(manga *domain.MangaDetails, mangaDir string, chapters []domain.Chapter) error {
+	for _, c := range chapters {
+		if _, err := m.Queue.Add(manga, c, mangaDir, queue.PriorityNormal); err != nil {
+			return err
+		}
+		m.TotalChapters++
+	}
+	m.addLog(fmt.Sprintf("Queued %d chapters of %s", len(chapters), manga.Title))
+	return nil
+}
 
-				downloadChan <- ProgressMsg{Done: -1, Total: total, Message: msg}
-			}(chapter)
+// retry fetches the series of the failed history entries again and queues
+// their chapters; see RetryFetchedMsg.
+func (m *Model) retry(entries []history.Entry) tea.Cmd {
+	m.HistoryNotice = ""
+	m.State = StatusFetching
+	return retryCmd(context.Background(), m.Scraper, entries)
+}
+
+func retryCmd(ctx context.Context, s *scraper.Scraper, entries []history.Entry) tea.Cmd {
+	return func() tea.Msg {
+		var msg RetryFetchedMsg
+		for _, group := range history.BySeries(entries) {
+			latest := group[len(group)-1]
+			details, err := s.FetchMangaDetails(ctx, latest.SeriesURL)
+			if err != nil {
+				msg.Problems = append(msg.Problems, fmt.Sprintf("%s: %v", latest.Series, err))
+				continue
+			}
+			chapters, missing := history.Match(details, group)
+			for _, e := range missing {
+				msg.Problems = append(msg.Problems, fmt.Sprintf("%s: %s is no longer listed", details.Title, e.Chapter))
+			}
+			if len(chapters) > 0 {
+				msg.Batches = append(msg.Batches, RetryBatch{Series: details, Dir: latest.Dir, Chapters: chapters})
+			}
 		}
+		return msg
+	}
+}
+
+// openHistory loads the download history and shows it.
+func (m *Model) openHistory() {
+	m.HistoryEntries, m.HistoryNotice, m.HistoryCursor = nil, "", 0
+	if m.History == nil {
+		m.HistoryNotice = "The history file could not be opened"
+	} else if entries, err := history.Load(m.History.Path()); err != nil {
+		m.HistoryNotice = err.Error()
+	} else {
+		m.HistoryEntries = entries
+	}
+	m.historyReturn = m.State
+	m.State = StatusHistory
+}
+
+// closeHistory returns to the screen the history was opened from, or
+// finishes the download shown there if it is over.
+func (m *Model) closeHistory() tea.Cmd {
+	m.State = m.historyReturn
+	if m.State == StatusDownloading && m.downloadOver() {
+		return m.finishDownload()
+	}
+	return nil
+}
 
-		wg.Wait()
-		close(downloadChan)
-	}()
+// record appends a job that finished with err to the history.
+func (m *Model) record(job queue.Job, err error) {
+	entry := history.NewEntry(job.Series, job.Dir, job.Chapter, job.Started, job.Result, err)
+	if err := m.History.Record(entry); err != nil {
+		m.addLog(fmt.Sprintf("Cannot record history: %v", err))
+	}
+}
 
-	return waitForDownloadMsg
+// waitForQueueEvent delivers the next queue event as a QueueEventMsg.
+func waitForQueueEvent(events <-chan queue.Event) tea.Cmd {
+	return func() tea.Msg {
+		ev, ok := <-events
+		if !ok {
+			return DownloadCompleteMsg{}
+		}
+		return QueueEventMsg(ev)
+	}
 }
 
-func waitForDownloadMsg() tea.Msg {
-	msg, ok := <-downloadChan
-	if !ok {
-		return DownloadCompleteMsg{}
+// handleQueueEvent updates the dashboard for ev and reports whether the
+// download is over: nothing is left to run and no job is paused. Chapters
+// cancelled before they started are left out of the results.
+func (m *Model) handleQueueEvent(ev queue.Event) bool {
+	job := ev.Job
+	result := job.Result
+	if result == nil {
+		result = &domain.ChapterResult{Chapter: job.Chapter}
 	}
-	return msg
+	name := job.Chapter.Name
+	m.trackActive(ev)
+
+	var msg string
+	switch ev.Type {
+	case queue.EventStarted:
+		msg = fmt.Sprintf("Started: %s", name)
+		if job.Attempts > 1 {
+			msg = fmt.Sprintf("Started: %s (attempt %d)", name, job.Attempts)
+		}
+	case queue.EventPaused:
+		msg = fmt.Sprintf("Paused: %s", name)
+		if job.Result != nil {
+			msg = fmt.Sprintf("Paused: %s (%d/%d pages saved)", name, result.PagesDone(), result.Total)
+		}
+	case queue.EventResumed:
+		msg = fmt.Sprintf("Resumed: %s", name)
+	case queue.EventRetrying:
+		msg = fmt.Sprintf("Retrying: %s (%v)", name, job.Err)
+	case queue.EventDone:
+		m.DoneChapters++
+		m.Results = append(m.Results, ChapterOutcome{Result: *result})
+		m.record(job, nil)
+		m.collectVolume(job, job.Result)
+		msg = fmt.Sprintf("Finished: %s (%s)", name, result.Summary())
+	case queue.EventFailed:
+		m.DoneChapters++
+		m.Results = append(m.Results, ChapterOutcome{Result: *result, Err: job.Err})
+		m.record(job, job.Err)
+		m.collectVolume(job, nil)
+		msg = fmt.Sprintf("Failed: %s (%v)", name, job.Err)
+		if result.Retries > 0 {
+			msg = fmt.Sprintf("Failed: %s (%v, %d retries)", name, job.Err, result.Retries)
+		}
+	case queue.EventCancelled:
+		if job.Attempts == 0 {
+			return false
+		}
+		m.DoneChapters++
+		m.Results = append(m.Results, ChapterOutcome{Result: *result, Err: context.Canceled})
+		m.record(job, context.Canceled)
+		m.collectVolume(job, nil)
+		msg = fmt.Sprintf("Cancelled: %s (%d/%d pages saved)", name, result.PagesDone(), result.Total)
+	case queue.EventIdle:
+		return (m.State == StatusDownloading || m.quitting) && m.downloadOver()
+	}
+
+	if msg != "" {
+		m.CurrentStatus = msg
+		m.addLog(msg)
+	}
+	return false
 }
 
 func (m *Model) getSelectedChapters() []domain.Chapter {
@@ -279,10 +622,70 @@ func (m *Model) getSelectedChapters() []domain.Chapter {
 	return chaps
 }
 
+// trackActive keeps Active and Bytes up to date with ev.
+func (m *Model) trackActive(ev queue.Event) {
+	job := ev.Job
+	i := slices.IndexFunc(m.Active, func(j queue.Job) bool { return j.ID == job.ID })
+	switch ev.Type {
+	case queue.EventStarted:
+		m.jobBytes[job.ID] = 0
+		if i < 0 {
+			m.Active = append(m.Active, job)
+		}
+	case queue.EventProgress:
+		m.Bytes += job.Progress.Bytes - m.jobBytes[job.ID]
+		m.jobBytes[job.ID] = job.Progress.Bytes
+		if i >= 0 {
+			m.Active[i] = job
+		}
+	case queue.EventPaused, queue.EventRetrying, queue.EventDone, queue.EventFailed, queue.EventCancelled:
+		delete(m.jobBytes, job.ID)
+		if i >= 0 {
+			m.Active = slices.Delete(m.Active, i, i+1)
+		}
+	}
+}
+
+// downloadOver reports whether every queued chapter has finished.
+func (m *Model) downloadOver() bool {
+	for _, j := range m.Queue.Jobs() {
+		if !j.State.Finished() {
+			return false
+		}
+	}
+	return true
+}
+
+// cancelDownload cancels every job. Chapters in flight finish their current
+// requests and record their progress before the done screen is shown.
+func (m *Model) cancelDownload() {
+	if m.Queue == nil {
+		return
+	}
+	m.Cancelled = true
+	m.addLog("Cancelling download, saving progress...")
+	m.Queue.CancelAll()
+}
+
+// togglePause pauses every unfinished job, or resumes them if they were
+// paused. Paused chapters keep the pages saved so far.
+func (m *Model) togglePause() {
+	if m.Paused {
+		m.Paused = false
+		m.addLog("Resuming downloads...")
+		m.Queue.ResumeAll()
+		return
+	}
+	m.Paused = true
+	m.addLog("Pausing downloads, saving progress...")
+	m.Queue.PauseAll()
+}
+
 func (m *Model) addLog(msg string) {
 	ts := time.Now().Format("15:04:05")
 	entry := fmt.Sprintf("[%s] %s", ts, msg)
 	m.Logs = append(m.Logs, entry)
+	m.Log.Info(msg)
 
 	maxLogs := 20
 	if len(m.Logs) > maxLogs {
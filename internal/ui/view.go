@@ -1,11 +1,18 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+
+	"mangadl/internal/history"
+	"mangadl/internal/scraper"
+	"mangadl/internal/selection"
 )
 
 func (m Model) View() string {
@@ -29,6 +36,8 @@ func (m Model) View() string {
 			content = m.viewDownloading()
 		case StatusDone:
 			content = m.viewDone()
+		case StatusHistory:
+			content = m.viewHistory()
 		default:
 			content = "Unknown state"
 		}
@@ -52,12 +61,23 @@ func (m Model) View() string {
 	case StatusDownloading:
 		statusText = "DOWNLOADING"
 		statusColor = Green
+		switch {
+		case m.Cancelled:
+			statusText = "CANCELLING"
+			statusColor = Orange
+		case m.Paused:
+			statusText = "PAUSED"
+			statusColor = Orange
+		}
 	case StatusDone:
 		statusText = "COMPLETED"
 		statusColor = Pink
 	case StatusError:
 		statusText = "ERROR"
 		statusColor = Red
+	case StatusHistory:
+		statusText = "HISTORY"
+		statusColor = Purple
 	}
 
 	status := lipgloss.NewStyle().Foreground(statusColor).Bold(true).Render(statusText)
@@ -68,7 +88,25 @@ func (m Model) View() string {
 	}
 	headerBar := lipgloss.JoinHorizontal(lipgloss.Center, strings.Repeat(" ", gap), status)
 
-	footer := FooterStyle.Render(" Ctrl+C: Quit • Esc: Back")
+	help := " Ctrl+C: Quit • Esc: Back"
+	switch {
+	case m.State == StatusInput:
+		help = " Enter: Fetch • Ctrl+R: History • Esc: Back • Ctrl+C: Quit"
+	case m.State == StatusHistory:
+		help = " ↑/↓: Move • f: Failed only • r: Retry chapter • R: Retry all failed • Esc: Back"
+	case m.State == StatusDone:
+		help = " Enter: Quit • h: History"
+	case m.State == StatusSelection && m.RangeInput.Focused():
+		help = " Enter: Apply selection • Esc: Back"
+	case m.State == StatusSelection:
+		help = fmt.Sprintf(" Space: Toggle • a: All • s: Select range • /: Filter • Enter: Download %d • Ctrl+C: Quit", len(m.Selected))
+	case m.State == StatusDownloading:
+		help = " p: Pause/resume • n: Add series • h: History • c: Cancel download • Ctrl+C: Cancel and quit"
+		if m.Cancelled {
+			help = " Cancelling, saving progress... • Ctrl+C: Quit now"
+		}
+	}
+	footer := FooterStyle.Render(help)
 
 	return lipgloss.JoinVertical(lipgloss.Left,
 		headerBar,
@@ -104,7 +142,8 @@ func (m Model) viewInput() string {
 		),
 	)
 
-	tips := TipsStyle.Render("Supported Sites: MangaKatana\nExample: https://mangakatana.com/manga/one-piece.20")
+	tips := TipsStyle.Render("Supported Sites: " + strings.Join(scraper.SourceNames(), ", ") +
+		"\nExample: https://mangakatana.com/manga/one-piece.20")
 
 	// Hide tips if height is very constrained
 	if m.Height < 15 {
@@ -141,6 +180,24 @@ func (m Model) viewSelection() string {
 		filterView = lipgloss.NewStyle().MarginBottom(1).Render(filterView)
 	}
 
+	// Selection prompt with a preview of the chapters it resolves to
+	var rangeView string
+	if m.RangeInput.Focused() {
+		rangeView = lipgloss.JoinVertical(lipgloss.Left,
+			lipgloss.JoinHorizontal(lipgloss.Left,
+				lipgloss.NewStyle().Foreground(Pink).Bold(true).Render("SELECT: "),
+				m.RangeInput.View(),
+			),
+			m.selectionPreview(),
+		)
+		rangeView = lipgloss.NewStyle().MarginBottom(1).Render(rangeView)
+		if filterView == "" {
+			filterView = rangeView
+		} else {
+			filterView = lipgloss.JoinVertical(lipgloss.Left, filterView, rangeView)
+		}
+	}
+
 	// 2. Grid Content
 	if len(m.FilteredChapters) == 0 {
 		return lipgloss.JoinVertical(lipgloss.Left,
@@ -156,7 +213,7 @@ func (m Model) viewSelection() string {
 	// Height - Header(1) - Footer(1) - DocPadding(2) - FilterHeight
 	availHeight := m.Height - 4
 	if filterView != "" {
-		availHeight -= 2 // Margin + height
+		availHeight -= lipgloss.Height(filterView)
 	}
 
 	if availHeight < 1 {
@@ -227,10 +284,7 @@ func (m Model) viewSelection() string {
 			maxNameLen = 5
 		}
 
-		name := c.Name
-		if len(name) > maxNameLen {
-			name = name[:maxNameLen-3] + "..."
-		}
+		name := truncate(c.Name, maxNameLen)
 
 		itemStr := fmt.Sprintf("%s %s", checkStyle.Render(check), nameStyle.Render(name))
 
@@ -258,26 +312,51 @@ func (m Model) viewSelection() string {
 	)
 }
 
+// selectionPreview describes the chapters the selection prompt resolves to,
+// or why it cannot be parsed.
+func (m Model) selectionPreview() string {
+	sel, err := selection.Parse(m.RangeInput.Value())
+	if err != nil {
+		return lipgloss.NewStyle().Foreground(Red).Render(err.Error())
+	}
+	chapters := sel.Select(m.Manga.Chapters)
+	preview := fmt.Sprintf("%d chapters: %s", len(chapters), selection.Describe(chapters))
+	return SubtleStyle.Width(max(20, m.Width-6)).Render(preview)
+}
+
 func (m Model) viewDownloading() string {
 	// Top: Progress
 	m.Progress.Width = max(0, m.Width-10)
 	// Use View() as state is updated in Update()
 	progView := m.Progress.View()
 
+	eta := "--"
+	if d, ok := m.ETA(); ok {
+		eta = d.Round(time.Second).String()
+	}
 	stats := lipgloss.JoinHorizontal(lipgloss.Top,
 		StatLabelStyle.Render("PROGRESS"),
 		StatValueStyle.Render(fmt.Sprintf("%d/%d", m.DoneChapters, m.TotalChapters)),
 		"   ",
 		StatLabelStyle.Render("ELAPSED"),
 		StatValueStyle.Render(time.Since(m.StartTime).Round(time.Second).String()),
+		"   ",
+		StatLabelStyle.Render("SPEED"),
+		StatValueStyle.Render(formatRate(m.Speed())),
+		"   ",
+		StatLabelStyle.Render("RECEIVED"),
+		StatValueStyle.Render(formatBytes(m.Bytes)),
+		"   ",
+		StatLabelStyle.Render("ETA"),
+		StatValueStyle.Render(eta),
 	)
 
+	blocks := []string{stats, "", progView}
+	if chapters := m.viewActiveChapters(max(1, (m.Height-7)/3)); chapters != "" {
+		blocks = append(blocks, "", chapters)
+	}
 	topBlock := ProgressContainerStyle.Width(max(0, m.Width-4)).Render(
-		lipgloss.JoinVertical(lipgloss.Left,
-			stats,
-			"",
-			progView,
-		),
+		lipgloss.JoinVertical(lipgloss.Left, blocks...),
 	)
 
 	// Calculate available height for logs
@@ -351,28 +430,194 @@ func (m Model) viewDownloading() string {
 	)
 }
 
+// viewActiveChapters renders a progress bar per running chapter, at most
+// maxRows of them.
+func (m Model) viewActiveChapters(maxRows int) string {
+	if len(m.Active) == 0 {
+		return ""
+	}
+	nameWidth := max(10, min(30, m.Width/4))
+	bar := m.ChapterBar
+	bar.Width = max(10, m.Width-nameWidth-40)
+
+	var rows []string
+	for i, j := range m.Active {
+		if i == maxRows-1 && len(m.Active) > maxRows {
+			rows = append(rows, SubtleStyle.Render(fmt.Sprintf("...and %d more", len(m.Active)-i)))
+			break
+		}
+		p := j.Progress
+		frac, pages := 0.0, "fetching pages"
+		if p.PagesTotal > 0 {
+			frac = float64(p.PagesDone) / float64(p.PagesTotal)
+			pages = fmt.Sprintf("%d/%d pages • %s", p.PagesDone, p.PagesTotal, formatRate(p.Speed))
+		}
+		name := truncate(j.Chapter.Name, nameWidth)
+		rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Top,
+			lipgloss.NewStyle().Width(nameWidth+1).Render(name),
+			bar.ViewAs(frac),
+			" ",
+			SubtleStyle.Render(pages),
+		))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, rows...)
+}
+
+// truncate shortens s to at most width terminal cells, ending it with
+// "..." if it was cut. Wide characters such as CJK count as two cells and
+// are never split.
+func truncate(s string, width int) string {
+	return ansi.Truncate(s, width, "...")
+}
+
+// formatBytes renders a byte count such as "12.3 MB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// formatRate renders a speed in bytes per second, such as "1.2 MB/s".
+func formatRate(bytesPerSec float64) string {
+	return formatBytes(int64(bytesPerSec)) + "/s"
+}
+
 func (m Model) viewDone() string {
 	boxWidth := 60
 	if m.Width < 64 {
 		boxWidth = m.Width - 4
 	}
 
+	failed := m.FailedResults()
+	pages, pagesFailed := 0, 0
+	for _, r := range m.Results {
+		pages += r.Result.PagesDone()
+		if !errors.Is(r.Err, context.Canceled) {
+			pagesFailed += len(r.Result.Failed)
+		}
+	}
+
+	title := lipgloss.NewStyle().Foreground(Green).Bold(true).Render("DOWNLOAD COMPLETE")
+	border := Green
+	switch {
+	case m.Cancelled:
+		title = lipgloss.NewStyle().Foreground(Orange).Bold(true).Render("DOWNLOAD CANCELLED")
+		border = Orange
+	case len(failed) > 0:
+		title = lipgloss.NewStyle().Foreground(Red).Bold(true).Render("DOWNLOAD FINISHED WITH ERRORS")
+		border = Red
+	}
+
+	interrupted := m.InterruptedResults()
+	lines := []string{
+		title,
+		"",
+		fmt.Sprintf("%d chapters ok • %d failed", len(m.Results)-len(failed)-len(interrupted), len(failed)),
+		fmt.Sprintf("%d pages saved • %d pages failed", pages, pagesFailed),
+	}
+	if m.Cancelled {
+		lines = append(lines,
+			fmt.Sprintf("%d chapters interrupted • %d not started", len(interrupted), m.TotalChapters-len(m.Results)),
+			SubtleStyle.Render("Progress is saved; download again to resume."),
+		)
+	}
+
+	// List as many failed chapters as fit, most useful first.
+	maxListed := max(0, m.Height-20)
+	for i, f := range failed {
+		if i == maxListed {
+			lines = append(lines, SubtleStyle.Render(fmt.Sprintf("...and %d more", len(failed)-i)))
+			break
+		}
+		line := fmt.Sprintf("✗ %s: %s", f.Result.Chapter.Name, f.Result.Summary())
+		if len(f.Result.Failed) == 0 {
+			line = fmt.Sprintf("✗ %s: %v", f.Result.Chapter.Name, f.Err)
+		}
+		lines = append(lines, lipgloss.NewStyle().Foreground(Red).Width(boxWidth-4).Render(line))
+	}
+
+	lines = append(lines,
+		"",
+		fmt.Sprintf("Files saved to %s", m.Config.OutputDir),
+		"",
+		SubtleStyle.Render("Press Enter to quit or h for the history"),
+	)
+
 	box := InputBoxStyle.
 		Width(boxWidth).
-		BorderForeground(Green).
-		Render(
-			lipgloss.JoinVertical(lipgloss.Center,
-				lipgloss.NewStyle().Foreground(Green).Bold(true).Render("DOWNLOAD COMPLETE"),
-				"",
-				"Files saved to ./output/",
-				"",
-				SubtleStyle.Render("Press Enter to quit"),
-			),
-		)
+		BorderForeground(border).
+		Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
 
 	return lipgloss.Place(m.Width, max(0, m.Height-5), lipgloss.Center, lipgloss.Center, box)
 }
 
+func (m Model) viewHistory() string {
+	entries := m.VisibleHistory()
+	failed := len(history.Failed(m.HistoryEntries))
+
+	title := fmt.Sprintf("%d downloads recorded • %d chapters failed", len(m.HistoryEntries), failed)
+	if m.HistoryFailedOnly {
+		title = fmt.Sprintf("%d chapters whose last attempt failed", len(entries))
+	}
+	lines := []string{lipgloss.NewStyle().Foreground(Pink).Bold(true).Render(title)}
+	if m.HistoryNotice != "" {
+		lines = append(lines, lipgloss.NewStyle().Foreground(Red).Render(m.HistoryNotice))
+	}
+	lines = append(lines, "")
+	if len(entries) == 0 {
+		lines = append(lines, SubtleStyle.Render("Nothing downloaded yet."))
+		return lipgloss.JoinVertical(lipgloss.Left, lines...)
+	}
+
+	// Keep the cursor in view.
+	visible := max(1, m.Height-6-len(lines))
+	start := max(0, m.HistoryCursor-visible+1)
+	end := min(len(entries), start+visible)
+
+	width := max(20, m.Width-4)
+	for i := start; i < end; i++ {
+		e := entries[i]
+		statusStyle := lipgloss.NewStyle().Foreground(Green)
+		detail := fmt.Sprintf("%d/%d pages • %s • %s", e.PagesDone, e.Pages, formatBytes(e.Bytes), e.Duration().Round(time.Second))
+		switch e.Status {
+		case history.StatusFailed:
+			statusStyle = lipgloss.NewStyle().Foreground(Red)
+			detail = e.Error
+		case history.StatusCancelled:
+			statusStyle = SubtleStyle
+		}
+		if e.Skipped {
+			detail = "already downloaded"
+		}
+
+		nameStyle := lipgloss.NewStyle().Foreground(Foreground)
+		cursor := "  "
+		if i == m.HistoryCursor {
+			nameStyle = nameStyle.Foreground(Pink).Bold(true)
+			cursor = "> "
+		}
+		line := lipgloss.JoinHorizontal(lipgloss.Top,
+			cursor,
+			SubtleStyle.Render(e.Finished.Format("01-02 15:04")+" "),
+			statusStyle.Width(10).Render(string(e.Status)),
+			nameStyle.Render(e.Series+" • "+e.Chapter),
+			SubtleStyle.Render("  "+detail),
+		)
+		lines = append(lines, lipgloss.NewStyle().MaxWidth(width).Render(line))
+	}
+	if end < len(entries) {
+		lines = append(lines, SubtleStyle.Render(fmt.Sprintf("...and %d older", len(entries)-end)))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
 func (m Model) viewError() string {
 	boxWidth := 60
 	if m.Width < 64 {
@@ -5,13 +5,35 @@ import (
 	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"mangadl/internal/cli"
+	"mangadl/internal/config"
+	"mangadl/internal/downloader"
+	"mangadl/internal/scraper"
 	"mangadl/internal/ui"
 )
 
 func main() {
-	p := tea.NewProgram(ui.InitialModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	// A subcommand selects the headless CLI; without one the TUI starts.
+	// Either way the global flags and --config are applied first.
+	os.Exit(cli.Main(os.Args[1:], os.Stdout, os.Stderr, runTUI))
+}
+
+func runTUI(cfg config.Config) int {
+	s := scraper.New(cfg)
+	d := downloader.New(cfg, s)
+
+	m := ui.InitialModel(cfg, s, d)
+	log, closeLog := cli.OpenLog(cfg, os.Stderr)
+	m.Log = log
+	m.History = cli.OpenHistory(os.Stderr)
+
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	_, err := p.Run()
+	m.History.Close()
+	closeLog()
+	if err != nil {
 		fmt.Printf("Error: %v", err)
-		os.Exit(1)
+		return cli.ExitFailure
 	}
+	return cli.ExitOK
 }